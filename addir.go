@@ -0,0 +1,141 @@
+package lansrv
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// AdDir is the default directory holding standalone ad definitions in YAML.
+const AdDir = "/etc/lansrv.d"
+
+// adFields maps the keys accepted in an ad definition to the LanAd field they set.
+var adFields = map[string]string{
+	"service":   "Service",
+	"name":      "Service",
+	"port":      "Port",
+	"path":      "Path",
+	"protocol":  "Protocol",
+	"transport": "Transport",
+	"user":      "User",
+	"tags":      "Tags",
+}
+
+// GatherAdFiles returns the `.yaml` and `.yml` files in @arg dir sorted by name, or none when
+// @arg dir is empty.
+func GatherAdFiles(dir string) []string {
+	files := []string{}
+	if len(dir) == 0 {
+		return files
+	}
+	for _, pattern := range []string{"*.yaml", "*.yml"} {
+		matches, _ := filepath.Glob(filepath.Join(dir, pattern))
+		files = append(files, matches...)
+	}
+	sort.Strings(files)
+
+	return files
+}
+
+// ParseAdFile reads the ads defined in the YAML file at @arg path.  Each document in the file is
+// either a single ad or a list of them, using the same keys as a [LanSrv] section in lower case:
+//
+//   - service: files
+//     port: 9999
+//     path: /share
+//
+// Every valid ad is returned even when others are not.  The error then lists each invalid entry
+// with the file and line it is on.
+func ParseAdFile(path string) ([]LanAd, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	ads := []LanAd{}
+	problems := []string{}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		doc := new(yaml.Node)
+		if err := decoder.Decode(doc); err == io.EOF {
+			break
+		} else if err != nil {
+			return ads, fmt.Errorf("%s: %v", path, err)
+		}
+		if len(doc.Content) == 0 {
+			continue
+		}
+
+		entries := []*yaml.Node{doc.Content[0]}
+		if doc.Content[0].Kind == yaml.SequenceNode {
+			entries = doc.Content[0].Content
+		}
+
+		for _, entry := range entries {
+			ad, line, err := adFromNode(entry)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s:%d: %v", path, line, err))
+				continue
+			}
+			ads = append(ads, ad)
+		}
+	}
+
+	if len(problems) > 0 {
+		return ads, errors.New(strings.Join(problems, "\n"))
+	}
+
+	return ads, nil
+}
+
+// adFromNode builds an ad from a YAML mapping, returning the line of any problem found.
+func adFromNode(node *yaml.Node) (LanAd, int, error) {
+	if node.Kind != yaml.MappingNode {
+		return LanAd{}, node.Line, errors.New("ad must be a mapping of keys to values")
+	}
+
+	adMap := make(map[string]string)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+
+		field, ok := adFields[strings.ToLower(key.Value)]
+		if !ok {
+			return LanAd{}, key.Line, fmt.Errorf("unknown key %q", key.Value)
+		}
+		if field == "Tags" && value.Kind == yaml.SequenceNode {
+			tags := []string{}
+			for _, tag := range value.Content {
+				if tag.Kind != yaml.ScalarNode {
+					return LanAd{}, tag.Line, fmt.Errorf("%s must be a list of values", key.Value)
+				}
+				tags = append(tags, tag.Value)
+			}
+			adMap[field] = strings.Join(tags, ",")
+			continue
+		}
+		if value.Kind != yaml.ScalarNode {
+			return LanAd{}, value.Line, fmt.Errorf("%s must be a single value", key.Value)
+		}
+		adMap[field] = value.Value
+	}
+
+	ad := LanAd{}
+	err := ad.FromMap(adMap)
+	switch {
+	case len(ad.Service) == 0:
+		return LanAd{}, node.Line, errors.New("missing service")
+	case ad.Port <= 0 || ad.Port > 65535:
+		return LanAd{}, node.Line, fmt.Errorf("invalid port %q", adMap["Port"])
+	case err != nil:
+		return LanAd{}, node.Line, err
+	}
+
+	return ad, 0, nil
+}
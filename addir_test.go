@@ -0,0 +1,43 @@
+package lansrv
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAdFile(t *testing.T) {
+	dir := t.TempDir()
+	writeUnitFile(t, filepath.Join(dir, "media.yaml"), `- service: files
+  port: 9999
+  path: /share
+- name: mqtt
+  port: 1883
+  protocol: mqtt
+  transport: tcp
+- service: broken
+  port: lots
+- service: typo
+  prot: 80
+---
+service: printer
+port: 631
+protocol: ipp
+tags: [office, ipp]
+`)
+	writeUnitFile(t, filepath.Join(dir, "z.yml"), "service: z\nport: 1\n")
+	writeUnitFile(t, filepath.Join(dir, "ignored.txt"), "service: x\nport: 1\n")
+
+	files := GatherAdFiles(dir)
+	assert.Equal(t, []string{filepath.Join(dir, "media.yaml"), filepath.Join(dir, "z.yml")}, files)
+	assert.Empty(t, GatherAdFiles(""))
+
+	ads, err := ParseAdFile(files[0])
+	assert.Equal(t, []LanAd{
+		{Service: "files", Port: 9999, Path: "/share", Protocol: "http"},
+		{Service: "mqtt", Port: 1883, Protocol: "mqtt", Transport: "tcp"},
+		{Service: "printer", Port: 631, Protocol: "ipp", Tags: []string{"office", "ipp"}},
+	}, ads)
+	assert.EqualError(t, err, files[0]+`:8: invalid port "lots"`+"\n"+files[0]+`:11: unknown key "prot"`)
+}
@@ -0,0 +1,152 @@
+package lansrv
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// AvahiServiceDir is where avahi-daemon reads static service definitions from.
+const AvahiServiceDir = "/etc/avahi/services"
+
+// avahiServiceGroup is the root element of an Avahi .service file, see avahi.service(5).
+type avahiServiceGroup struct {
+	XMLName  xml.Name       `xml:"service-group"`
+	Name     avahiName      `xml:"name"`
+	Services []avahiService `xml:"service"`
+}
+
+type avahiName struct {
+	ReplaceWildcards string `xml:"replace-wildcards,attr,omitempty"`
+	Value            string `xml:",chardata"`
+}
+
+type avahiService struct {
+	Type       string   `xml:"type"`
+	Port       int      `xml:"port"`
+	TxtRecords []string `xml:"txt-record"`
+}
+
+// GatherAvahiServices returns the `.service` files in @arg dir sorted by name, or none when
+// @arg dir is empty.
+func GatherAvahiServices(dir string) []string {
+	if len(dir) == 0 {
+		return []string{}
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.service"))
+	sort.Strings(files)
+
+	return files
+}
+
+// ParseAvahiService reads the Avahi service group at @arg path, returning an ad for each service in
+// it.  The service type `_http._tcp` sets the protocol and transport and the ad's service name is
+// taken from a `lansrv-service=` txt record, falling back on the group name.  A `path=` txt record,
+// as used for HTTP services, sets the path.  Services that can't be parsed are skipped and
+// reported in the error along with the ads of the others.
+func ParseAvahiService(path string) ([]LanAd, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	group := avahiServiceGroup{}
+	if err := xml.Unmarshal(data, &group); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	name := strings.TrimSpace(group.Name.Value)
+	if group.Name.ReplaceWildcards == "yes" {
+		host, _ := os.Hostname()
+		name = strings.ReplaceAll(name, "%h", host)
+	}
+
+	ads := []LanAd{}
+	problems := []string{}
+	for _, service := range group.Services {
+		protocol, transport, err := parseAvahiType(service.Type)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", path, err))
+			continue
+		}
+
+		adMap := map[string]string{
+			"Service":   name,
+			"Port":      strconv.Itoa(service.Port),
+			"Protocol":  protocol,
+			"Transport": transport,
+		}
+		for _, record := range service.TxtRecords {
+			switch {
+			case strings.HasPrefix(record, "lansrv-service="):
+				adMap["Service"] = strings.TrimPrefix(record, "lansrv-service=")
+			case strings.HasPrefix(record, "path="):
+				adMap["Path"] = strings.TrimPrefix(record, "path=")
+			}
+		}
+
+		ad := LanAd{}
+		if err := ad.FromMap(adMap); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %s service: %v", path, service.Type, err))
+			continue
+		}
+		ads = append(ads, ad)
+	}
+
+	if len(problems) > 0 {
+		return ads, errors.New(strings.Join(problems, "\n"))
+	}
+
+	return ads, nil
+}
+
+// AvahiFileName returns the name of the file AvahiService(@arg ad) is exported to.  Path
+// separators in the service name are replaced by underscores, so the file stays in its directory.
+func AvahiFileName(ad LanAd) string {
+	name := strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '\\', 0:
+			return '_'
+		}
+		return r
+	}, ad.Service)
+
+	return "lansrv-" + name + "-" + strconv.Itoa(ad.Port) + ".service"
+}
+
+// AvahiService renders @arg ad as an Avahi service group file.
+func AvahiService(ad LanAd) []byte {
+	service := avahiService{
+		Type:       "_" + ad.Protocol + "._" + ad.transport(),
+		Port:       ad.Port,
+		TxtRecords: []string{"lansrv-service=" + ad.Service},
+	}
+	if len(ad.Path) > 0 {
+		service.TxtRecords = append(service.TxtRecords, "path="+ad.Path)
+	}
+
+	group := avahiServiceGroup{
+		Name:     avahiName{ReplaceWildcards: "yes", Value: ad.Service + " on %h"},
+		Services: []avahiService{service},
+	}
+
+	data, _ := xml.MarshalIndent(group, "", "  ")
+	return []byte(xml.Header + `<!DOCTYPE service-group SYSTEM "avahi-service.dtd">` + "\n" + string(data) + "\n")
+}
+
+// parseAvahiType splits a DNS-SD service type such as `_http._tcp` into protocol and transport.
+func parseAvahiType(serviceType string) (string, string, error) {
+	parts := strings.Split(strings.TrimSpace(serviceType), ".")
+	if len(parts) != 2 || !strings.HasPrefix(parts[0], "_") || !strings.HasPrefix(parts[1], "_") {
+		return "", "", errors.New("invalid service type " + strconv.Quote(serviceType))
+	}
+
+	return strings.TrimPrefix(parts[0], "_"), strings.TrimPrefix(parts[1], "_"), nil
+}
@@ -0,0 +1,77 @@
+package lansrv
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAvahiService(t *testing.T) {
+	dir := t.TempDir()
+	writeUnitFile(t, filepath.Join(dir, "printer.service"), `<?xml version="1.0" standalone='no'?>
+<!DOCTYPE service-group SYSTEM "avahi-service.dtd">
+<service-group>
+  <name replace-wildcards="yes">Printer on %h</name>
+  <service>
+    <type>_ipp._tcp</type>
+    <port>631</port>
+    <txt-record>lansrv-service=printer</txt-record>
+  </service>
+  <service>
+    <type>_http._tcp</type>
+    <port>80</port>
+    <txt-record>path=/admin</txt-record>
+  </service>
+</service-group>
+`)
+	writeUnitFile(t, filepath.Join(dir, "ignored.xml"), "<service-group/>")
+
+	files := GatherAvahiServices(dir)
+	assert.Equal(t, []string{filepath.Join(dir, "printer.service")}, files)
+	assert.Empty(t, GatherAvahiServices(""))
+
+	ads, err := ParseAvahiService(files[0])
+	assert.NoError(t, err)
+	assert.Len(t, ads, 2)
+	assert.Equal(t, LanAd{Service: "printer", Port: 631, Protocol: "ipp", Transport: "tcp"}, ads[0])
+	assert.Equal(t, "/admin", ads[1].Path)
+	assert.Contains(t, ads[1].Service, "Printer on ")
+}
+
+func TestAvahiServiceRoundTrip(t *testing.T) {
+	ad := LanAd{Service: "syslog", Port: 514, Path: "/logs", Protocol: "syslog", Transport: "udp"}
+
+	path := filepath.Join(t.TempDir(), "syslog.service")
+	assert.NoError(t, ioutil.WriteFile(path, AvahiService(ad), 0644))
+
+	ads, err := ParseAvahiService(path)
+	assert.NoError(t, err)
+	assert.Equal(t, []LanAd{ad}, ads)
+}
+
+func TestParseAvahiServiceSkipsBadServices(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mixed.service")
+	writeUnitFile(t, path, `<service-group>
+  <name>files</name>
+  <service>
+    <type>http</type>
+    <port>80</port>
+  </service>
+  <service>
+    <type>_http._tcp</type>
+    <port>8080</port>
+  </service>
+</service-group>
+`)
+
+	ads, err := ParseAvahiService(path)
+	assert.EqualError(t, err, path+`: invalid service type "http"`)
+	assert.Equal(t, []LanAd{{Service: "files", Port: 8080, Protocol: "http", Transport: "tcp"}}, ads)
+}
+
+func TestAvahiFileName(t *testing.T) {
+	assert.Equal(t, "lansrv-files-9999.service", AvahiFileName(LanAd{Service: "files", Port: 9999}))
+	assert.Equal(t, "lansrv-.._.._etc_cron.d-80.service", AvahiFileName(LanAd{Service: "../../etc/cron.d", Port: 80}))
+}
@@ -0,0 +1,66 @@
+package lansrv
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// CheckListening verifies that one of @arg listeners, as returned by ListeningSockets, accepts
+// connections for @arg ad from the network.  The ad's Address, when set, must be served by a
+// listener of the same address family, a dual stack wildcard or that exact address.
+func CheckListening(ad LanAd, listeners []Listener) error {
+	transport := ad.transport()
+
+	loopbackOnly := false
+	for _, listener := range listeners {
+		if listener.Port != ad.Port || listener.Transport != transport {
+			continue
+		}
+
+		ipv4 := len(listener.IP) == 4
+		if ad.Address != nil {
+			wantIPv4 := ad.Address.To4() != nil
+			switch {
+			case listener.IP.IsUnspecified() && (ipv4 == wantIPv4 || !ipv4):
+			case listener.IP.Equal(ad.Address):
+			default:
+				continue
+			}
+		}
+
+		if listener.IP.IsLoopback() {
+			loopbackOnly = true
+			continue
+		}
+
+		return nil
+	}
+
+	endpoint := transport + " port " + strconv.Itoa(ad.Port)
+	if ad.Address != nil {
+		endpoint += " on " + ad.Address.String()
+	}
+	if loopbackOnly {
+		return fmt.Errorf("%s is only listening on loopback", endpoint)
+	}
+
+	return fmt.Errorf("nothing is listening on %s", endpoint)
+}
+
+// CheckAds runs CheckListening against the current listening sockets for each of @arg ads and
+// returns the failures indexed like @arg ads.
+func CheckAds(ads []LanAd) (map[int]error, error) {
+	listeners, err := ListeningSockets()
+	if err != nil {
+		return nil, err
+	}
+
+	failures := make(map[int]error)
+	for i, ad := range ads {
+		if err := CheckListening(ad, listeners); err != nil {
+			failures[i] = err
+		}
+	}
+
+	return failures, nil
+}
@@ -0,0 +1,26 @@
+package lansrv
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckListening(t *testing.T) {
+	procFixture(t)
+	listeners, err := ListeningSockets()
+	assert.NoError(t, err)
+
+	assert.NoError(t, CheckListening(LanAd{Port: 8080}, listeners))
+	assert.NoError(t, CheckListening(LanAd{Port: 5353, Transport: "udp"}, listeners))
+	assert.NoError(t, CheckListening(LanAd{Port: 80, Address: net.ParseIP("192.168.1.4")}, listeners),
+		"dual stack wildcard should accept ipv4")
+
+	assert.EqualError(t, CheckListening(LanAd{Port: 8080, Address: net.ParseIP("fe80::1")}, listeners),
+		"nothing is listening on tcp port 8080 on fe80::1")
+	assert.EqualError(t, CheckListening(LanAd{Port: 8080, Transport: "udp"}, listeners),
+		"nothing is listening on udp port 8080")
+	assert.EqualError(t, CheckListening(LanAd{Port: 3306}, listeners),
+		"tcp port 3306 is only listening on loopback")
+}
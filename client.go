@@ -0,0 +1,176 @@
+package lansrv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Dialer connects to services by name, e.g. `DialContext(ctx, "tcp", "nats-node")`, spreading the
+// connections over their instances round robin and failing over to another instance when one
+// can't be dialed.  The services found by a scan are used for CacheFor, then scanned again on the
+// next dial.  Running a Watcher with the Dialer as one of its sinks keeps them current instead.
+type Dialer struct {
+	*balancer
+	// ScanTimeout is how long a scan takes and CacheFor how long its results are used.
+	ScanTimeout time.Duration
+	CacheFor    time.Duration
+	// Localhost includes services published by this host.
+	Localhost bool
+	// Lookup is used to scan the network, ServicesLookup when nil.
+	Lookup func(ctx context.Context, localhost bool) (ServiceSet, error)
+	// Dialer makes the connections.
+	Dialer net.Dialer
+
+	scanning sync.Mutex
+	mutex    sync.Mutex
+	updated  time.Time
+}
+
+// NewDialer returns a dialer that scans for 5 seconds and caches the results for a minute.
+func NewDialer() *Dialer {
+	return &Dialer{balancer: newBalancer(), ScanTimeout: 5 * time.Second, CacheFor: time.Minute}
+}
+
+func (dialer *Dialer) Update(services ServiceSet, events []ServiceEvent) error {
+	dialer.mutex.Lock()
+	dialer.updated = time.Now()
+	dialer.mutex.Unlock()
+
+	return dialer.balancer.Update(services, events)
+}
+
+// scanned keeps the services of a Watcher current while the network is quiet.
+func (dialer *Dialer) scanned() error {
+	dialer.mutex.Lock()
+	dialer.updated = time.Now()
+	dialer.mutex.Unlock()
+
+	return nil
+}
+
+// Dial connects to @arg address without a context, see DialContext.
+func (dialer *Dialer) Dial(network, address string) (net.Conn, error) {
+	return dialer.DialContext(context.Background(), network, address)
+}
+
+// DialContext connects to an instance of the service named by @arg address whose transport
+// matches @arg network.  A port in @arg address is ignored in favor of the advertised one, so the
+// dialer can be used by clients that always add one.  When no instance can be dialed and the
+// services weren't just scanned, they're scanned again in case the service moved.
+func (dialer *Dialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	service := address
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		service = host
+	}
+
+	scanned, err := dialer.refresh(ctx, service, false)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, _, err := dialer.dial(ctx, &dialer.Dialer, network, service)
+	if err != nil && !scanned && ctx.Err() == nil {
+		if _, err = dialer.refresh(ctx, service, true); err == nil {
+			conn, _, err = dialer.dial(ctx, &dialer.Dialer, network, service)
+		}
+	}
+
+	return conn, err
+}
+
+// refresh scans the network when @arg force is set, the cached services are older than CacheFor
+// or @arg service isn't among them, and reports whether it did.
+func (dialer *Dialer) refresh(ctx context.Context, service string, force bool) (bool, error) {
+	dialer.scanning.Lock()
+	defer dialer.scanning.Unlock()
+
+	dialer.mutex.Lock()
+	fresh := time.Since(dialer.updated) < dialer.CacheFor
+	dialer.mutex.Unlock()
+	if !force && fresh && dialer.known(service) {
+		return false, nil
+	}
+
+	lookup := dialer.Lookup
+	if lookup == nil {
+		lookup = ServicesLookup
+	}
+
+	scanCtx, cancel := context.WithTimeout(ctx, dialer.ScanTimeout)
+	found, err := lookup(scanCtx, dialer.Localhost)
+	cancel()
+	if err != nil {
+		return false, err
+	}
+	// a scan cut short by the caller may have missed services
+	if ctx.Err() != nil {
+		return false, ctx.Err()
+	}
+
+	return true, dialer.Update(found, nil)
+}
+
+// Transport is an http.RoundTripper for `lansrv://<service>/<path>` URLs.  It sends each request
+// to an instance of the service with the `http` or `https` protocol at
+// `<protocol>://<address>:<port>/<ad path>/<path>`, found with its Dialer, failing over to another
+// instance when one can't be connected to.  Other URLs are sent unchanged with Base, so the
+// transport can replace an http.Client's, or be added to an http.Transport with
+// `RegisterProtocol("lansrv", transport)`.
+type Transport struct {
+	Dialer *Dialer
+	Base   http.RoundTripper
+}
+
+// NewTransport returns a transport finding services with @arg dialer and sending requests with
+// http.DefaultTransport.
+func NewTransport(dialer *Dialer) *Transport {
+	return &Transport{Dialer: dialer, Base: http.DefaultTransport}
+}
+
+func (transport *Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.URL.Scheme != "lansrv" {
+		return transport.Base.RoundTrip(r)
+	}
+
+	service := r.URL.Hostname()
+	scanned, err := transport.Dialer.refresh(r.Context(), service, false)
+	if err != nil {
+		return nil, err
+	}
+
+	out := r.Clone(r.Context())
+	out.Host = ""
+	out.URL.RawPath = ""
+	replayable, err := replayBody(out)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := transport.send(out, service)
+	if err != nil && replayable && !scanned && r.Context().Err() == nil && (errors.Is(err, errNoInstance) || isDialError(err)) {
+		if _, err = transport.Dialer.refresh(r.Context(), service, true); err != nil {
+			return nil, err
+		}
+		if err = rewindBody(out); err != nil {
+			return nil, err
+		}
+		resp, err = transport.send(out, service)
+	}
+
+	return resp, err
+}
+
+// send sends @arg r to the first instance of @arg service that can be connected to.
+func (transport *Transport) send(r *http.Request, service string) (*http.Response, error) {
+	instances := transport.Dialer.pick(service, isHTTP)
+	if len(instances) == 0 {
+		return nil, fmt.Errorf("%w of %s with the http protocol", errNoInstance, service)
+	}
+
+	return (&failoverTransport{base: transport.Base, balancer: transport.Dialer.balancer, instances: instances}).RoundTrip(r)
+}
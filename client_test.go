@@ -0,0 +1,162 @@
+package lansrv
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// scripted returns a lookup answering with each of @arg results in turn, then the last one, and
+// counts the scans in @arg scans.
+func scripted(scans *int, results ...ServiceSet) func(context.Context, bool) (ServiceSet, error) {
+	var mutex sync.Mutex
+	return func(context.Context, bool) (ServiceSet, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		result := results[len(results)-1]
+		if *scans < len(results) {
+			result = results[*scans]
+		}
+		*scans++
+		return result, nil
+	}
+}
+
+func TestDialerCaches(t *testing.T) {
+	scans := 0
+	dialer := NewDialer()
+	dialer.Lookup = scripted(&scans, ServiceSet{"a": {echoServer(t, "a")}})
+
+	for _, address := range []string{"nats-node", "nats-node:4222"} {
+		conn, err := dialer.DialContext(context.Background(), "tcp", address)
+		assert.NoError(t, err)
+		reply, _ := sendLine(t, conn, "ping")
+		assert.Equal(t, "a ping\n", reply)
+		conn.Close()
+	}
+	assert.Equal(t, 1, scans)
+
+	_, err := dialer.Dial("tcp", "printer")
+	assert.EqualError(t, err, "no instance of printer")
+	assert.Equal(t, 2, scans)
+
+	_, err = dialer.Dial("udp", "nats-node")
+	assert.Error(t, err)
+}
+
+func TestDialerRescansWhenServiceMoved(t *testing.T) {
+	scans := 0
+	dialer := NewDialer()
+	dialer.Lookup = scripted(&scans, ServiceSet{"a": {deadAd(t, "nats-node")}}, ServiceSet{"b": {echoServer(t, "b")}})
+
+	// the first scan only finds the dead instance
+	_, err := dialer.Dial("tcp", "nats-node")
+	assert.Error(t, err)
+
+	conn, err := dialer.Dial("tcp", "nats-node")
+	assert.NoError(t, err)
+	defer conn.Close()
+	reply, _ := sendLine(t, conn, "ping")
+	assert.Equal(t, "b ping\n", reply)
+	assert.Equal(t, 2, scans)
+}
+
+func TestDialerFedByWatcher(t *testing.T) {
+	scans := 0
+	dialer := NewDialer()
+	dialer.CacheFor = 50 * time.Millisecond
+	dialer.Lookup = scripted(&scans, ServiceSet{})
+
+	watcherScans := 0
+	watcher := &Watcher{
+		Sinks:    []Sink{dialer},
+		Interval: 10 * time.Millisecond,
+		Timeout:  time.Second,
+		Lookup:   scripted(&watcherScans, ServiceSet{"a": {echoServer(t, "a")}}),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		watcher.Run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// the watcher's scans keep the services current though none of them changes anything
+	for i := 0; i < 3; i++ {
+		time.Sleep(dialer.CacheFor)
+		conn, err := dialer.Dial("tcp", "nats-node")
+		if assert.NoError(t, err) {
+			conn.Close()
+		}
+	}
+	assert.Equal(t, 0, scans)
+}
+
+func TestTransport(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Host + " " + r.URL.RequestURI()))
+	}))
+	defer backend.Close()
+	files := backendAd(t, "files", "share", backend)
+
+	scans := 0
+	dialer := NewDialer()
+	dialer.Lookup = scripted(&scans, ServiceSet{"a": {deadAd(t, "files")}, "b": {files}})
+	client := &http.Client{Transport: NewTransport(dialer)}
+
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get("lansrv://files/docs/a.txt?x=1")
+		assert.NoError(t, err)
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.Equal(t, strings.TrimPrefix(backend.URL, "http://")+" /share/docs/a.txt?x=1", string(body))
+	}
+	assert.Equal(t, 1, scans)
+
+	resp, err := client.Get(backend.URL + "/plain")
+	assert.NoError(t, err)
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.True(t, strings.HasSuffix(string(body), " /plain"))
+
+	_, err = client.Get("lansrv://printer/")
+	assert.Error(t, err)
+}
+
+func TestTransportResendsBodyAfterRescan(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Write(body)
+	}))
+	defer backend.Close()
+
+	scans := 0
+	dialer := NewDialer()
+	dialer.Lookup = scripted(&scans, ServiceSet{"a": {deadAd(t, "files")}}, ServiceSet{"b": {backendAd(t, "files", "", backend)}})
+	client := &http.Client{Transport: NewTransport(dialer)}
+
+	// the first scan only finds the dead instance
+	_, err := client.Get("lansrv://files/")
+	assert.Error(t, err)
+
+	// without GetBody, so the transport has to buffer the body itself
+	resp, err := client.Post("lansrv://files/upload", "text/plain", io.MultiReader(strings.NewReader("payload")))
+	assert.NoError(t, err)
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, "payload", string(body))
+	assert.Equal(t, 2, scans)
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/alittlebrighter/lansrv"
+	"gopkg.in/yaml.v3"
+)
+
+// defaultConfigFile is read when it exists unless another file is given with -config.
+const defaultConfigFile = "/etc/lansrv/lansrv.yaml"
+
+// config is the daemon configuration read from the config file.  Flags take precedence over the
+// values in the file.
+type config struct {
+	Service    string         `yaml:"service"`
+	Domain     string         `yaml:"domain"`
+	Interfaces listValue      `yaml:"interfaces"`
+	Security   securityConfig `yaml:"security"`
+	Server     serverConfig   `yaml:"server"`
+	Scan       scanConfig     `yaml:"scan"`
+	Watch      watchConfig    `yaml:"watch"`
+	Metrics    string         `yaml:"metrics"`
+}
+
+// securityConfig holds the settings for signing ads with a key shared by the nodes on the LAN.
+type securityConfig struct {
+	KeyFile string `yaml:"key_file"`
+}
+
+// scanConfig holds the settings for scanning the network for ads.
+type scanConfig struct {
+	Time      int    `yaml:"time"`
+	AdService string `yaml:"service"`
+	Format    string `yaml:"format"`
+	Delimiter string `yaml:"delim"`
+	Localhost bool   `yaml:"localhost"`
+}
+
+func defaultConfig() *config {
+	return &config{
+		Service: lansrv.Service,
+		Domain:  lansrv.Domain,
+		Server:  *defaultServerConfig(),
+		Scan: scanConfig{
+			Time:      5,
+			Format:    lansrv.Protocol + "://" + lansrv.Address + ":" + lansrv.Port + lansrv.Path,
+			Delimiter: ",",
+		},
+		Watch: defaultWatchConfig(),
+	}
+}
+
+// loadConfig reads the config file named by a -config flag in @arg args, or the default config
+// file if it exists, on top of the default settings.
+func loadConfig(args []string) (*config, error) {
+	cfg := defaultConfig()
+
+	path, explicit := configFile(args)
+	f, err := os.Open(path)
+	if os.IsNotExist(err) && !explicit {
+		return cfg, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	decoder := yaml.NewDecoder(f)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(cfg); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	return cfg, nil
+}
+
+// configFile returns the -config flag in @arg args and whether it was given.  It parses them ahead
+// of the command's own flags, skipping the flags it doesn't know along with their values, and stops
+// where flag parsing does: at `--` or the first argument that isn't a flag, which belong to the
+// command.
+func configFile(args []string) (string, bool) {
+	fs := flag.NewFlagSet("config", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	fs.Usage = func() {}
+
+	// the shared flags, and the boolean flags of single commands, which take no value to skip
+	cfg := defaultConfig()
+	cfg.flags(fs)
+	cfg.Server.flags(fs)
+	cfg.Scan.flags(fs)
+	cfg.Watch.flags(fs)
+	cfg.metricsFlag(fs)
+	fs.Bool("scan", false, "")
+	fs.Bool("restart", false, "")
+
+	for len(args) > 0 {
+		err := fs.Parse(args)
+		if err == nil {
+			break
+		}
+
+		// parsing stops after a failed flag, whose value follows it unless it was given with =
+		failed := args[len(args)-len(fs.Args())-1]
+		args = fs.Args()
+		unknown := strings.HasPrefix(err.Error(), "flag provided but not defined")
+		if unknown && !strings.Contains(failed, "=") && len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+			args = args[1:]
+		}
+	}
+
+	explicit := false
+	fs.Visit(func(f *flag.Flag) {
+		explicit = explicit || f.Name == "config"
+	})
+
+	return fs.Lookup("config").Value.String(), explicit
+}
+
+// flags registers the settings shared by every command on @arg fs.
+func (cfg *config) flags(fs *flag.FlagSet) {
+	fs.StringVar(&cfg.Service, "service", cfg.Service, "Service to scan for.")
+	cfg.networkFlags(fs)
+}
+
+// networkFlags registers the shared settings other than -service, which commands acting on a
+// single discovered service use for its name instead.
+func (cfg *config) networkFlags(fs *flag.FlagSet) {
+	fs.String("config", defaultConfigFile, "YAML file to read settings from.  Flags override its values.")
+	fs.StringVar(&cfg.Domain, "domain", cfg.Domain, "mDNS domain to publish and scan in.")
+	fs.Var(&cfg.Interfaces, "interfaces", "Comma delimited list of network interfaces to use.  Defaults to all multicast interfaces.")
+	fs.StringVar(&cfg.Security.KeyFile, "key", cfg.Security.KeyFile,
+		"File holding a key shared with the other nodes.  Published ads are signed with it and discovered ads without a valid signature are dropped.")
+}
+
+// flags registers the scan settings on @arg fs using the current values as defaults.
+func (cfg *scanConfig) flags(fs *flag.FlagSet) {
+	fs.IntVar(&cfg.Time, "time", cfg.Time, "Number of seconds to scan the local network for services.")
+	fs.StringVar(&cfg.AdService, "adService", cfg.AdService, "Only print results matching the service name.")
+	fs.StringVar(&cfg.Format, "format", cfg.Format, `Print results in a custom format delimited by the delim flag.  Keys start and end with %.
+Valid keys are pro=protocol, addr=IP address, port=port, svc=service.`)
+	fs.StringVar(&cfg.Delimiter, "delim", cfg.Delimiter, "Delimiter to use when only printing specific service endpoints.")
+	fs.BoolVar(&cfg.Localhost, "localhost", cfg.Localhost, "Include services hosted on this computer.")
+}
+
+// apply sets the lansrv package settings from the configuration.
+func (cfg *config) apply() error {
+	lansrv.Service = cfg.Service
+	lansrv.Domain = cfg.Domain
+	lansrv.TTL = uint32(cfg.Server.TTL)
+
+	lansrv.Interfaces = nil
+	for _, name := range cfg.Interfaces {
+		iface, err := net.InterfaceByName(name)
+		if err != nil {
+			return fmt.Errorf("interface %s: %v", name, err)
+		}
+		lansrv.Interfaces = append(lansrv.Interfaces, *iface)
+	}
+
+	lansrv.SigningKey = nil
+	if len(cfg.Security.KeyFile) > 0 {
+		key, err := ioutil.ReadFile(cfg.Security.KeyFile)
+		if err != nil {
+			return err
+		}
+		if key = []byte(strings.TrimSpace(string(key))); len(key) == 0 {
+			return errors.New("key file " + cfg.Security.KeyFile + " is empty")
+		}
+		lansrv.SigningKey = key
+	}
+
+	return nil
+}
+
+// runConfig handles `lansrv config print`, which shows the effective configuration after merging
+// the config file and flags.
+func runConfig(args []string) {
+	if len(args) == 0 || args[0] != "print" {
+		fmt.Println("Usage: lansrv config print [flags]")
+		os.Exit(2)
+	}
+
+	cfg, err := loadConfig(args[1:])
+	if err != nil {
+		fmt.Println("Failed to load config:", err)
+		os.Exit(1)
+	}
+
+	fs := flag.NewFlagSet("config print", flag.ExitOnError)
+	cfg.flags(fs)
+	cfg.Server.flags(fs)
+	cfg.Scan.flags(fs)
+	cfg.Watch.flags(fs)
+	cfg.metricsFlag(fs)
+	fs.Parse(args[1:])
+
+	encoder := yaml.NewEncoder(os.Stdout)
+	encoder.SetIndent(2)
+	encoder.Encode(cfg)
+	encoder.Close()
+}
+
+// listValue is a list setting given as a comma delimited flag or a YAML list.
+type listValue []string
+
+func (list *listValue) String() string {
+	if list == nil {
+		return ""
+	}
+	return strings.Join(*list, ",")
+}
+
+func (list *listValue) Set(value string) error {
+	*list = splitList(value)
+	return nil
+}
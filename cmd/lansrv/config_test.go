@@ -0,0 +1,34 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigFile(t *testing.T) {
+	tests := []struct {
+		args     string
+		path     string
+		explicit bool
+	}{
+		{"", defaultConfigFile, false},
+		{"-config /tmp/a.yaml", "/tmp/a.yaml", true},
+		{"--config=/tmp/a.yaml -time 3", "/tmp/a.yaml", true},
+		// flags the pre-pass doesn't know are skipped with their values
+		{"-env NATS_ROUTES -config /tmp/a.yaml", "/tmp/a.yaml", true},
+		{"-listen=:4222 -localhost -config /tmp/a.yaml", "/tmp/a.yaml", true},
+		{"-restart -signal HUP -config /tmp/a.yaml", "/tmp/a.yaml", true},
+		// the command's own flags are left alone
+		{"-service nats-node -env NATS_ROUTES -- nats-server --config /etc/nats/nats.conf", defaultConfigFile, false},
+		{"-restart nats-server -config /etc/nats/nats.conf", defaultConfigFile, false},
+		{"-config /tmp/a.yaml -- app -config /tmp/b.yaml", "/tmp/a.yaml", true},
+	}
+
+	for _, test := range tests {
+		path, explicit := configFile(strings.Fields(test.args))
+		assert.Equal(t, test.path, path, test.args)
+		assert.Equal(t, test.explicit, explicit, test.args)
+	}
+}
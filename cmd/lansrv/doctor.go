@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/alittlebrighter/lansrv"
+)
+
+// runDoctor checks that something is listening for each ad the server would publish and exits
+// with a non-zero status when any of them would advertise a dead endpoint.
+func runDoctor(args []string) {
+	cfg, err := loadConfig(args)
+	if err != nil {
+		fmt.Println("Failed to load config:", err)
+		os.Exit(1)
+	}
+
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+	cfg.flags(fs)
+	cfg.Server.flags(fs)
+	fs.Parse(args)
+
+	if err := cfg.apply(); err != nil {
+		fmt.Println("Invalid config:", err)
+		os.Exit(1)
+	}
+
+	source, err := cfg.Server.source()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	ads, err := source.Load(context.Background())
+	if err != nil {
+		fmt.Println("Could not load all ads:", err)
+	}
+	if len(ads) == 0 {
+		fmt.Println("No LanSrv configurations found.")
+		return
+	}
+
+	failures, err := lansrv.CheckAds(ads)
+	if err != nil {
+		fmt.Println("Could not read listening sockets:", err)
+		os.Exit(1)
+	}
+
+	for i, ad := range ads {
+		if err, failed := failures[i]; failed {
+			fmt.Printf("FAIL %s: %s\n", describeAd(ad), err)
+		} else {
+			fmt.Printf("OK   %s\n", describeAd(ad))
+		}
+	}
+
+	if len(failures) > 0 {
+		os.Exit(1)
+	}
+}
+
+// warnUnreachable logs every ad that nothing on this host is listening for.
+func warnUnreachable(ads []lansrv.LanAd) {
+	failures, err := lansrv.CheckAds(ads)
+	if err != nil {
+		fmt.Println("Could not check advertised ports:", err)
+		return
+	}
+
+	for i, err := range failures {
+		fmt.Printf("Warning: advertising %s but %s\n", describeAd(ads[i]), err)
+	}
+}
+
+func describeAd(ad lansrv.LanAd) string {
+	desc := ad.ToFormattedString(lansrv.AdService + " " + lansrv.Protocol + "://:" + lansrv.Port + lansrv.Path)
+	if len(ad.User) > 0 {
+		desc += " (user " + ad.User + ")"
+	}
+
+	return desc
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/alittlebrighter/lansrv"
+)
+
+// runExec runs a command with the endpoints of discovered services in its environment, e.g.
+// `lansrv exec -service nats-node -env NATS_ROUTES -- nats-server`, and exits with its status.
+func runExec(args []string) {
+	cfg, err := loadConfig(args)
+	if err != nil {
+		fmt.Println("Failed to load config:", err)
+		os.Exit(1)
+	}
+
+	fs := flag.NewFlagSet("exec", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: lansrv exec [flags] -- command [args...]")
+		fs.PrintDefaults()
+	}
+	cfg.networkFlags(fs)
+	cfg.Scan.flags(fs)
+	cfg.Watch.flags(fs)
+	cfg.metricsFlag(fs)
+	fs.StringVar(&cfg.Scan.AdService, "service", cfg.Scan.AdService, "Service whose endpoints are exported by -env flags without a service.")
+	env := specList{}
+	fs.Var(&env, "env", "Environment variable to export the endpoints of -service to, or VAR=service for another service.  May be repeated.")
+	restart := fs.Bool("restart", false, "Restart the command when the endpoints change.")
+	signalName := fs.String("signal", "", "Signal to send the command when the endpoints change instead of restarting it, e.g. HUP.")
+	fs.Parse(args)
+
+	if err := cfg.apply(); err != nil {
+		fmt.Println("Invalid config:", err)
+		os.Exit(1)
+	}
+
+	bindings, err := envBindings(env, cfg.Scan.AdService)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+
+	process, err := lansrv.NewProcessSink(fs.Args(), bindings, cfg.Scan.Format, cfg.Scan.Delimiter)
+	if err != nil {
+		fs.Usage()
+		os.Exit(2)
+	}
+	process.Restart = *restart
+	if len(*signalName) > 0 {
+		if process.Signal, err = lansrv.ParseSignal(*signalName); err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+	}
+
+	if err := serveMetrics(cfg.Metrics); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	watcher, err := cfg.Watch.watcher(cfg.Scan)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	watcher.Sinks = append(watcher.Sinks, process)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go watcher.Run(ctx)
+
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGUSR1, syscall.SIGUSR2)
+	for {
+		select {
+		case sig := <-sigc:
+			// before the command starts the signal ends lansrv as it would have ended the command
+			if err := process.Kill(sig); errors.Is(err, lansrv.ErrNotStarted) {
+				cancel()
+				os.Exit(128 + int(sig.(syscall.Signal)))
+			}
+		case err := <-process.Exited():
+			cancel()
+			exitErr := &exec.ExitError{}
+			switch {
+			case errors.As(err, &exitErr):
+				os.Exit(exitCode(exitErr))
+			case err != nil:
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+}
+
+// exitCode returns the status to exit with for the command that failed with @arg exitErr.  A
+// command killed by a signal exits with 128 plus the signal number, as it does in a shell.
+func exitCode(exitErr *exec.ExitError) int {
+	if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+
+	return exitErr.ExitCode()
+}
+
+// envBindings parses -env flags given as VAR, exporting @arg service, or VAR=service.
+func envBindings(env []string, service string) ([]lansrv.EnvBinding, error) {
+	bindings := make([]lansrv.EnvBinding, len(env))
+	for i, binding := range env {
+		name, bound := binding, service
+		if i := strings.Index(binding, "="); i >= 0 {
+			name, bound = binding[:i], binding[i+1:]
+		}
+		if len(name) == 0 || len(bound) == 0 {
+			return nil, errors.New("-env " + binding + " needs a service, either with -service or as VAR=service")
+		}
+		bindings[i] = lansrv.EnvBinding{Name: name, Service: bound}
+	}
+
+	return bindings, nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/alittlebrighter/lansrv"
+)
+
+// runExport writes the ads the server would publish in another tool's format so services can be
+// moved between them.
+func runExport(args []string) {
+	cfg, err := loadConfig(args)
+	if err != nil {
+		fmt.Println("Failed to load config:", err)
+		os.Exit(1)
+	}
+
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	cfg.flags(fs)
+	cfg.Server.flags(fs)
+	format := "avahi"
+	fs.StringVar(&format, "format", format, "Format to export to.  Only avahi is supported.")
+	out := "."
+	fs.StringVar(&out, "out", out, "Directory to write the exported files to, e.g. "+lansrv.AvahiServiceDir)
+	fs.Parse(args)
+
+	if format != "avahi" {
+		fmt.Println("Unknown export format:", format)
+		os.Exit(2)
+	}
+
+	if err := cfg.apply(); err != nil {
+		fmt.Println("Invalid config:", err)
+		os.Exit(1)
+	}
+
+	source, err := cfg.Server.source()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	ads, err := source.Load(context.Background())
+	if err != nil {
+		fmt.Println("Could not load all ads:", err)
+	}
+
+	for _, ad := range ads {
+		path := filepath.Join(out, lansrv.AvahiFileName(ad))
+		if err := ioutil.WriteFile(path, lansrv.AvahiService(ad), 0644); err != nil {
+			fmt.Println("Failed to export", ad.Service+":", err)
+			os.Exit(1)
+		}
+		fmt.Println("Wrote", path)
+	}
+}
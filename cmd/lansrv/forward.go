@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/alittlebrighter/lansrv"
+)
+
+// runForward forwards local TCP connections to the discovered instances of a service.
+func runForward(args []string) {
+	cfg, err := loadConfig(args)
+	if err != nil {
+		fmt.Println("Failed to load config:", err)
+		os.Exit(1)
+	}
+
+	fs := flag.NewFlagSet("forward", flag.ExitOnError)
+	cfg.networkFlags(fs)
+	cfg.Scan.flags(fs)
+	cfg.Watch.flags(fs)
+	cfg.metricsFlag(fs)
+	forwarder := lansrv.NewForwarder("")
+	listen := "127.0.0.1:0"
+	fs.StringVar(&listen, "listen", listen, "Address to accept connections on, e.g. 127.0.0.1:4222.")
+	fs.StringVar(&forwarder.Service, "service", forwarder.Service, "Service to forward connections to.")
+	fs.DurationVar(&forwarder.RetryAfter, "retry-after", forwarder.RetryAfter, "Time to pass over an instance after failing to connect to it.")
+	fs.Parse(args)
+
+	if err := cfg.apply(); err != nil {
+		fmt.Println("Invalid config:", err)
+		os.Exit(1)
+	}
+	if len(forwarder.Service) == 0 {
+		fmt.Println("-service is required")
+		os.Exit(1)
+	}
+
+	if err := serveMetrics(cfg.Metrics); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	watcher, err := cfg.Watch.watcher(cfg.Scan)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	watcher.Sinks = append(watcher.Sinks, forwarder)
+
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Println("Forwarding", listener.Addr(), "to", forwarder.Service)
+	go func() {
+		if err := forwarder.Serve(listener); err != nil {
+			fmt.Println("Forwarder stopped:", err)
+			os.Exit(1)
+		}
+	}()
+
+	watcher.Run(interruptContext())
+}
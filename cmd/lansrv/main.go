@@ -2,134 +2,95 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"flag"
 	"fmt"
 	"os"
-	"os/signal"
 	"strings"
-	"syscall"
 	"time"
 
 	"github.com/alittlebrighter/lansrv"
 )
 
+// commands run instead of the default server or scan when named as the first argument.  Each
+// parses the remaining arguments with its own flags.
+var commands = map[string]func(args []string){
+	"config":      runConfig,
+	"doctor":      runDoctor,
+	"exec":        runExec,
+	"export":      runExport,
+	"forward":     runForward,
+	"nats-routes": runNatsRoutes,
+	"proxy":       runProxy,
+	"watch":       runWatch,
+}
+
 func main() {
-	walkDir := ""
-	flag.StringVar(&walkDir, "dir", walkDir,
-		"Specifies the directory to walk to find LanSrv service configurations.  For systemd this would normally be: /etc/systemd/system")
-	port := 42424
-	flag.IntVar(&port, "port", port, "Port to run the server on.")
-	publishServices := ""
-	flag.StringVar(&publishServices, "publish", publishServices, "Comma delimited list of services to advertise in the format `<protocol>://<service-name>:<port>` e.g. http://files:9999")
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			command(os.Args[2:])
+			return
+		}
+	}
+
+	cfg, err := loadConfig(os.Args[1:])
+	if err != nil {
+		fmt.Println("Failed to load config:", err)
+		os.Exit(1)
+	}
+
+	cfg.flags(flag.CommandLine)
+	cfg.Server.flags(flag.CommandLine)
+	cfg.Scan.flags(flag.CommandLine)
+	cfg.metricsFlag(flag.CommandLine)
 	scan := false
 	flag.BoolVar(&scan, "scan", scan,
 		"Scan the local network for services published by other LanSrv nodes.  If not set, the server will start.")
-	seconds := 5
-	flag.IntVar(&seconds, "time", seconds, "Number of seconds to scan the local network for services.")
-	adService := ""
-	flag.StringVar(&adService, "adService", adService, "Only print results matching the service name.")
-	format := lansrv.Protocol + "://" + lansrv.Address + ":" + lansrv.Port + lansrv.Path
-	flag.StringVar(&format, "format", format, `Print results in a custom format delimited by the delim flag.  Keys start and end with %.
-Valid keys are pro=protocol, addr=IP address, port=port, svc=service.`)
-	var delimiter string
-	flag.StringVar(&delimiter, "delim", ",", "Delimiter to use when only printing specific service endpoints.")
-	var localhost bool
-	flag.BoolVar(&localhost, "localhost", false,
-		"Include services hosted on this computer.")
-	flag.StringVar(&lansrv.Service, "service", lansrv.Service, "Service to scan for.")
 	flag.Parse()
 
+	if err := cfg.apply(); err != nil {
+		fmt.Println("Invalid config:", err)
+		os.Exit(1)
+	}
+
 	switch {
 	case scan:
-		runDiscovery(seconds, adService, format, delimiter, localhost)
+		runDiscovery(cfg.Scan)
 	default:
-		runServer(walkDir, strings.Split(publishServices, ","), port)
-	}
-}
-
-func runServer(scanDir string, services []string, port int) {
-	ads := make([]lansrv.LanAd, 0)
-
-	if len(scanDir) > 0 {
-		files := lansrv.GatherServiceConfigs(scanDir)
-		ads = append(ads, lansrv.ParseServiceFiles(files)...)
-	}
-
-svcs_loop:
-	for _, svc := range services {
-		ad := new(lansrv.LanAd)
-		ad.FromString(svc)
-
-		for _, listed := range ads {
-			if ad.EqualTo(&listed) {
-				continue svcs_loop
-			}
+		if err := serveMetrics(cfg.Metrics); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
 		}
-
-		ads = append(ads, *ad)
-	}
-
-	if len(ads) == 0 {
-		fmt.Println("No LanSrv configurations found.  Exiting now.")
-		return
-	}
-
-	fmt.Println("Serving:\n", ads)
-
-	server, err := lansrv.StartMdnsServer(ads, port)
-	if err != nil {
-		fmt.Println("Failed to start server:", err)
-		return
+		source, err := cfg.Server.source()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		// without a refresh or Docker nothing can add ads later
+		runServer(source, cfg.Server.Port, cfg.Server.Refresh > 0 || len(cfg.Server.Docker) > 0)
 	}
-	defer server.Shutdown()
-
-	fmt.Printf("mDNS server started on %d.\n", port)
-
-	sigc := make(chan os.Signal, 1)
-	signal.Notify(sigc,
-		syscall.SIGHUP,
-		syscall.SIGINT,
-		syscall.SIGTERM,
-		syscall.SIGQUIT,
-	)
-
-	<-sigc
 }
 
-func runDiscovery(seconds int, adService, format, delimiter string, localhost bool) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*time.Duration(seconds))
+func runDiscovery(cfg scanConfig) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*time.Duration(cfg.Time))
 	defer cancel()
 
-	networkAds, err := lansrv.ServicesLookup(ctx, localhost)
+	networkAds, err := lansrv.ServicesLookup(ctx, cfg.Localhost)
 	if err != nil {
 		fmt.Println("Failed to lookup services:", err)
 		return
 	}
 
-	if len(adService) > 0 {
-		svcEndpoints := make(map[string]interface{})
-		for _, svcs := range networkAds {
-			for _, svc := range svcs {
-				if svc.Service != adService {
-					continue
-				}
-
-				svcEndpoints[svc.ToFormattedString(format)] = struct{}{}
-			}
-		}
+	fmt.Print(string(lansrv.Render(networkAds, cfg.AdService, cfg.Format, cfg.Delimiter)))
+}
 
-		i := 0
-		svcList := make([]string, len(svcEndpoints))
-		for k := range svcEndpoints {
-			svcList[i] = k
-			i++
+// splitList splits a comma delimited flag value dropping any empty entries.
+func splitList(list string) []string {
+	items := []string{}
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); len(item) > 0 {
+			items = append(items, item)
 		}
-
-		fmt.Print(strings.Join(svcList, delimiter))
-		return
 	}
 
-	data, _ := json.Marshal(networkAds)
-	fmt.Println(string(data))
+	return items
 }
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/alittlebrighter/lansrv"
+)
+
+// metricsFlag registers the -metrics setting of the long running commands on @arg fs.
+func (cfg *config) metricsFlag(fs *flag.FlagSet) {
+	fs.StringVar(&cfg.Metrics, "metrics", cfg.Metrics, "Address to serve Prometheus metrics on at /metrics, e.g. :9112.  Disabled when empty.")
+}
+
+// serveMetrics serves the daemon's metrics on @arg address in the background if it is set.
+func serveMetrics(address string) error {
+	if len(address) == 0 {
+		return nil
+	}
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("metrics: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", lansrv.MetricsHandler())
+	go func() {
+		if err := http.Serve(listener, mux); err != nil {
+			fmt.Println("Metrics server stopped:", err)
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/alittlebrighter/lansrv"
+)
+
+// runNatsRoutes keeps a nats-server include file listing the routes to the other NATS nodes on the
+// network and signals nats-server to reload it when they change.
+func runNatsRoutes(args []string) {
+	cfg, err := loadConfig(args)
+	if err != nil {
+		fmt.Println("Failed to load config:", err)
+		os.Exit(1)
+	}
+
+	fs := flag.NewFlagSet("nats-routes", flag.ExitOnError)
+	cfg.networkFlags(fs)
+	cfg.Scan.flags(fs)
+	cfg.Watch.flags(fs)
+	cfg.metricsFlag(fs)
+	routes := lansrv.NewNatsRoutesSink("/etc/nats/routes.conf", "nats-node", "")
+	fs.StringVar(&routes.Service, "service", routes.Service, "Service advertised by the NATS nodes.")
+	fs.StringVar(&routes.Path, "conf", routes.Path, "Include file to write the cluster block to.")
+	fs.StringVar(&routes.PidFile, "pidfile", routes.PidFile, "nats-server pid file.  The process is sent SIGHUP when the routes change.")
+	fs.IntVar(&routes.RoutePort, "route-port", routes.RoutePort, "Cluster port of the nodes if their ads advertise another port.")
+	fs.StringVar(&routes.Listen, "listen", routes.Listen, "Cluster listen address to write to the cluster block, e.g. 0.0.0.0:6222.")
+	fs.StringVar(&routes.Name, "cluster", routes.Name, "Cluster name to write to the cluster block.")
+	fs.Parse(args)
+
+	if err := cfg.apply(); err != nil {
+		fmt.Println("Invalid config:", err)
+		os.Exit(1)
+	}
+
+	if err := serveMetrics(cfg.Metrics); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	watcher, err := cfg.Watch.watcher(cfg.Scan)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	watcher.Sinks = append(watcher.Sinks, routes)
+
+	watcher.Run(interruptContext())
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+
+	"github.com/alittlebrighter/lansrv"
+)
+
+// runProxy serves an HTTP reverse proxy routing requests by service name to the discovered
+// instances of the service.
+func runProxy(args []string) {
+	cfg, err := loadConfig(args)
+	if err != nil {
+		fmt.Println("Failed to load config:", err)
+		os.Exit(1)
+	}
+
+	fs := flag.NewFlagSet("proxy", flag.ExitOnError)
+	cfg.flags(fs)
+	cfg.Scan.flags(fs)
+	cfg.Watch.flags(fs)
+	cfg.metricsFlag(fs)
+	proxy := lansrv.NewServiceProxy()
+	listen := ":8080"
+	fs.StringVar(&listen, "listen", listen, "Address to serve the proxy on.")
+	fs.DurationVar(&proxy.RetryAfter, "retry-after", proxy.RetryAfter, "Time to pass over an instance after failing to connect to it.")
+	fs.Parse(args)
+
+	if err := cfg.apply(); err != nil {
+		fmt.Println("Invalid config:", err)
+		os.Exit(1)
+	}
+
+	if err := serveMetrics(cfg.Metrics); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	watcher, err := cfg.Watch.watcher(cfg.Scan)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	watcher.Sinks = append(watcher.Sinks, proxy)
+
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	go func() {
+		if err := http.Serve(listener, proxy); err != nil {
+			fmt.Println("Proxy stopped:", err)
+			os.Exit(1)
+		}
+	}()
+
+	watcher.Run(interruptContext())
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"os/user"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/alittlebrighter/lansrv"
+)
+
+// serverConfig holds the settings for finding and publishing the local ads.
+type serverConfig struct {
+	Dirs     listValue     `yaml:"dirs"`
+	AdDir    string        `yaml:"ads"`
+	Avahi    string        `yaml:"avahi"`
+	Docker   string        `yaml:"docker"`
+	Publish  listValue     `yaml:"publish"`
+	Port     int           `yaml:"port"`
+	User     bool          `yaml:"user"`
+	Users    bool          `yaml:"users"`
+	Instance string        `yaml:"instance"`
+	TTL      uint          `yaml:"ttl"`
+	Refresh  time.Duration `yaml:"refresh"`
+}
+
+func defaultServerConfig() *serverConfig {
+	return &serverConfig{
+		AdDir:   lansrv.AdDir,
+		Port:    42424,
+		Refresh: 30 * time.Second,
+	}
+}
+
+// flags registers the server settings on @arg fs using the current values as defaults.
+func (cfg *serverConfig) flags(fs *flag.FlagSet) {
+	fs.Var(&cfg.Dirs, "dir",
+		"Comma delimited list of directories to walk to find LanSrv service configurations, from highest to lowest precedence.  For systemd this would normally be: "+
+			strings.Join(lansrv.SystemUnitPaths, ","))
+	fs.StringVar(&cfg.AdDir, "ads", cfg.AdDir, "Directory of YAML files defining ads for services that aren't systemd units.")
+	fs.StringVar(&cfg.Avahi, "avahi", cfg.Avahi, "Directory of Avahi service files to advertise, e.g. "+lansrv.AvahiServiceDir)
+	fs.StringVar(&cfg.Docker, "docker", cfg.Docker,
+		"Unix socket of a Docker compatible API to advertise containers labeled with lansrv.service from, e.g. "+lansrv.DockerSocket)
+	fs.IntVar(&cfg.Port, "port", cfg.Port, "Port to run the server on.")
+	fs.Var(&cfg.Publish, "publish", "Comma delimited list of services to advertise in the format `<protocol>://<service-name>:<port>` e.g. http://files:9999")
+	fs.BoolVar(&cfg.User, "user", cfg.User,
+		"Run as a per-user instance advertising the current user's systemd --user units.  The instance name defaults to <user>@<host>.")
+	fs.BoolVar(&cfg.Users, "users", cfg.Users, "Also advertise the systemd --user units of every logged in user.")
+	fs.StringVar(&cfg.Instance, "instance", cfg.Instance, "mDNS instance name to register under.  Defaults to the hostname.")
+	fs.UintVar(&cfg.TTL, "ttl", cfg.TTL, "Time to live in seconds of the published records.  0 keeps the mDNS default.")
+	fs.DurationVar(&cfg.Refresh, "refresh", cfg.Refresh,
+		"How often to re-read service configurations and re-check Port=auto services.  0 disables it, SIGHUP always reloads.")
+}
+
+// source sets up the mDNS instance name and returns the source of every ad to publish.
+func (cfg *serverConfig) source() (*lansrv.MergedSource, error) {
+	lansrv.Instance = cfg.Instance
+
+	systemd := &lansrv.SystemdSource{Dirs: cfg.Dirs, AllUsers: cfg.Users, Refresh: cfg.Refresh}
+	if cfg.User {
+		current, err := user.Current()
+		if err != nil {
+			return nil, errors.New("Could not look up the current user: " + err.Error())
+		}
+		systemd.User = current
+
+		if len(lansrv.Instance) == 0 {
+			host, _ := os.Hostname()
+			lansrv.Instance = current.Username + "@" + host
+		}
+	}
+
+	sources := []lansrv.AdSource{
+		systemd,
+		&lansrv.AdDirSource{Dir: cfg.AdDir, Refresh: cfg.Refresh},
+	}
+	if len(cfg.Avahi) > 0 {
+		sources = append(sources, &lansrv.AvahiSource{Dir: cfg.Avahi, Refresh: cfg.Refresh})
+	}
+	if len(cfg.Docker) > 0 {
+		sources = append(sources, &lansrv.DockerSource{Client: lansrv.NewDockerClient(cfg.Docker)})
+	}
+	sources = append(sources, lansrv.NewFlagSource(cfg.Publish))
+
+	return &lansrv.MergedSource{Sources: append(sources, lansrv.RegisteredSources()...)}, nil
+}
+
+// runServer publishes the ads from @arg source, following its changes and reloading it on SIGHUP.
+// It exits when there are no ads to publish unless @arg follow says the source may add some later.
+func runServer(source *lansrv.MergedSource, port int, follow bool) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	updates := source.Watch(ctx)
+	ads := <-updates
+
+	if len(ads) == 0 && !follow {
+		fmt.Println("No LanSrv configurations found.  Exiting now.")
+		return
+	}
+
+	fmt.Println("Serving:\n", ads)
+	warnUnreachable(ads)
+
+	server, err := lansrv.StartMdnsServer(ads, port)
+	if err != nil {
+		fmt.Println("Failed to start server:", err)
+		return
+	}
+	defer server.Shutdown()
+
+	fmt.Printf("mDNS server started on %d.\n", port)
+	if err := lansrv.CountMdnsQueries(ctx); err != nil {
+		fmt.Println("Not counting mDNS queries:", err)
+	}
+
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc,
+		syscall.SIGHUP,
+		syscall.SIGINT,
+		syscall.SIGTERM,
+		syscall.SIGQUIT,
+	)
+
+	// signatures expire, so signed ads are signed again well before they do
+	var resign <-chan time.Time
+	if len(lansrv.SigningKey) > 0 {
+		ticker := time.NewTicker(lansrv.SignatureLifetime / 2)
+		defer ticker.Stop()
+		resign = ticker.C
+	}
+
+	for {
+		updated := ads
+		select {
+		case <-resign:
+			lansrv.UpdateMdnsServer(server, ads)
+			continue
+		case sig := <-sigc:
+			if sig != syscall.SIGHUP {
+				return
+			}
+
+			// the reloaded ads arrive with the updates when they changed
+			if err := source.Reload(ctx); err != nil {
+				fmt.Println("Could not load all ads:", err)
+			}
+			continue
+		case updated = <-updates:
+		}
+
+		if lansrv.SameAds(updated, ads) {
+			continue
+		}
+
+		ads = updated
+		fmt.Println("Serving:\n", ads)
+		warnUnreachable(ads)
+		lansrv.UpdateMdnsServer(server, ads)
+	}
+}
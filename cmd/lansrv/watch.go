@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/alittlebrighter/lansrv"
+)
+
+// watchConfig holds the settings for `lansrv watch`.
+type watchConfig struct {
+	Sinks    specList      `yaml:"sinks"`
+	Interval time.Duration `yaml:"interval"`
+	Misses   int           `yaml:"misses"`
+}
+
+func defaultWatchConfig() watchConfig {
+	return watchConfig{Interval: 30 * time.Second, Misses: 2}
+}
+
+// flags registers the watch settings on @arg fs using the current values as defaults.
+func (cfg *watchConfig) flags(fs *flag.FlagSet) {
+	fs.Var(&cfg.Sinks, "sink", "Sink to feed discovered services to as name:key=value,...  May be repeated.  Available sinks: "+
+		strings.Join(lansrv.SinkNames(), ", ")+".")
+	fs.DurationVar(&cfg.Interval, "interval", cfg.Interval, "Time between scans.")
+	fs.IntVar(&cfg.Misses, "misses", cfg.Misses, "Number of scans in a row a service must be missing from before it is removed.")
+}
+
+// watcher creates the sinks and returns a watcher scanning with the settings of @arg scan.
+func (cfg *watchConfig) watcher(scan scanConfig) (*lansrv.Watcher, error) {
+	sinks := make([]lansrv.Sink, len(cfg.Sinks))
+	for i, spec := range cfg.Sinks {
+		sink, err := lansrv.NewSink(spec)
+		if err != nil {
+			return nil, err
+		}
+		sinks[i] = sink
+	}
+
+	return &lansrv.Watcher{
+		Sinks:     sinks,
+		Interval:  cfg.Interval,
+		Timeout:   time.Second * time.Duration(scan.Time),
+		Localhost: scan.Localhost,
+		Misses:    cfg.Misses,
+	}, nil
+}
+
+// runWatch scans the network continuously, feeding the discovered services to the configured
+// sinks, printing change events when there are none.
+func runWatch(args []string) {
+	cfg, err := loadConfig(args)
+	if err != nil {
+		fmt.Println("Failed to load config:", err)
+		os.Exit(1)
+	}
+
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+	cfg.flags(fs)
+	cfg.Scan.flags(fs)
+	cfg.Watch.flags(fs)
+	cfg.metricsFlag(fs)
+	fs.Parse(args)
+
+	if err := cfg.apply(); err != nil {
+		fmt.Println("Invalid config:", err)
+		os.Exit(1)
+	}
+
+	if len(cfg.Watch.Sinks) == 0 {
+		cfg.Watch.Sinks = specList{"stdout:events=true"}
+	}
+	if err := serveMetrics(cfg.Metrics); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	watcher, err := cfg.Watch.watcher(cfg.Scan)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	watcher.Run(interruptContext())
+}
+
+// interruptContext returns a context that is done once the process is interrupted or terminated.
+func interruptContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigc
+		cancel()
+	}()
+
+	return ctx
+}
+
+// specList is a list setting given as a repeated flag or a YAML list.  Unlike listValue its items
+// may contain commas.
+type specList []string
+
+func (list *specList) String() string {
+	if list == nil {
+		return ""
+	}
+	return strings.Join(*list, " ")
+}
+
+func (list *specList) Set(value string) error {
+	*list = append(*list, value)
+	return nil
+}
@@ -0,0 +1,212 @@
+package lansrv
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// DockerSocket is where the Docker API is served by default.  Podman serves the same API, usually
+// on /run/podman/podman.sock.
+const DockerSocket = "/var/run/docker.sock"
+
+// Container labels read by DockerClient.ContainerAds.
+const (
+	dockerLabelService   = "lansrv.service"
+	dockerLabelPort      = "lansrv.port"
+	dockerLabelProtocol  = "lansrv.protocol"
+	dockerLabelPath      = "lansrv.path"
+	dockerLabelTransport = "lansrv.transport"
+	dockerLabelTags      = "lansrv.tags"
+)
+
+// DockerClient talks to a Docker compatible API over its unix socket to advertise containers
+// labeled with `lansrv.service`.
+type DockerClient struct {
+	client *http.Client
+}
+
+type dockerContainer struct {
+	Names      []string
+	Labels     map[string]string
+	Ports      []dockerPort
+	HostConfig struct {
+		NetworkMode string
+	}
+}
+
+type dockerPort struct {
+	IP          string
+	PrivatePort int
+	PublicPort  int
+	Type        string
+}
+
+// NewDockerClient returns a client for the API served on the unix socket at @arg socket.
+func NewDockerClient(socket string) *DockerClient {
+	dialer := new(net.Dialer)
+	return &DockerClient{
+		client: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					return dialer.DialContext(ctx, "unix", socket)
+				},
+			},
+		},
+	}
+}
+
+// ContainerAds returns an ad for every running container with a `lansrv.service` label.  The
+// `lansrv.port` label names the container port, which is advertised as the host port it is
+// published on.  It may be left out when the container publishes a single port.  The protocol,
+// path, transport and tags come from the `lansrv.protocol`, `lansrv.path`, `lansrv.transport` and
+// `lansrv.tags` labels.
+func (docker *DockerClient) ContainerAds(ctx context.Context) ([]LanAd, error) {
+	containers := []dockerContainer{}
+	if err := docker.get(ctx, "/containers/json", &containers); err != nil {
+		return nil, err
+	}
+
+	ads := []LanAd{}
+	for _, container := range containers {
+		service, ok := container.Labels[dockerLabelService]
+		if !ok {
+			continue
+		}
+
+		port, err := container.hostPort()
+		if err != nil {
+			fmt.Println("not advertising container", container.Names, "-", err)
+			continue
+		}
+
+		adMap := map[string]string{"Service": service, "Port": strconv.Itoa(port)}
+		for key, label := range map[string]string{
+			"Protocol":  dockerLabelProtocol,
+			"Path":      dockerLabelPath,
+			"Transport": dockerLabelTransport,
+			"Tags":      dockerLabelTags,
+		} {
+			if value, ok := container.Labels[label]; ok {
+				adMap[key] = value
+			}
+		}
+
+		ad := LanAd{}
+		if err := ad.FromMap(adMap); err == nil {
+			ads = append(ads, ad)
+		}
+	}
+
+	return ads, nil
+}
+
+// hostPort returns the host port the container's advertised port is reachable on.
+func (container *dockerContainer) hostPort() (int, error) {
+	transport := container.Labels[dockerLabelTransport]
+	if len(transport) == 0 {
+		transport = "tcp"
+	}
+
+	label, labeled := container.Labels[dockerLabelPort]
+	port, err := strconv.Atoi(label)
+	if labeled && err != nil {
+		return 0, fmt.Errorf("invalid %s label %q", dockerLabelPort, label)
+	}
+
+	if container.HostConfig.NetworkMode == "host" {
+		if !labeled {
+			return 0, fmt.Errorf("%s label is required with host networking", dockerLabelPort)
+		}
+		return port, nil
+	}
+
+	published := make(map[int]int)
+	for _, mapping := range container.Ports {
+		if mapping.PublicPort > 0 && mapping.Type == transport {
+			published[mapping.PrivatePort] = mapping.PublicPort
+		}
+	}
+
+	switch {
+	case labeled && published[port] > 0:
+		return published[port], nil
+	case labeled:
+		return 0, fmt.Errorf("%s port %d is not published", transport, port)
+	case len(published) == 1:
+		for _, public := range published {
+			return public, nil
+		}
+	}
+
+	return 0, fmt.Errorf("%s label is required when publishing %d ports", dockerLabelPort, len(published))
+}
+
+// Events notifies the returned channel whenever a container starts or stops, until @arg ctx is
+// done or the API closes the stream, at which point the channel is closed.
+func (docker *DockerClient) Events(ctx context.Context) (<-chan struct{}, error) {
+	filters, _ := json.Marshal(map[string][]string{
+		"type":  {"container"},
+		"event": {"start", "stop", "die", "pause", "unpause"},
+	})
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		"http://docker/events?filters="+url.QueryEscape(string(filters)), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := docker.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("docker events: %s", resp.Status)
+	}
+
+	changes := make(chan struct{}, 1)
+	go func() {
+		defer close(changes)
+		defer resp.Body.Close()
+
+		decoder := json.NewDecoder(resp.Body)
+		for {
+			event := make(map[string]interface{})
+			if err := decoder.Decode(&event); err != nil {
+				return
+			}
+
+			select {
+			case changes <- struct{}{}:
+			default:
+				// a change is already pending
+			}
+		}
+	}()
+
+	return changes, nil
+}
+
+func (docker *DockerClient) get(ctx context.Context, path string, result interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://docker"+path, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := docker.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("docker %s: %s", path, resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(result)
+}
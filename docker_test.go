@@ -0,0 +1,129 @@
+package lansrv
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const fakeContainers = `[
+	{"Names": ["/web"], "Labels": {"lansrv.service": "web", "lansrv.port": "80", "lansrv.path": "/app"},
+	 "Ports": [{"PrivatePort": 80, "PublicPort": 8080, "Type": "tcp"}, {"PrivatePort": 443, "PublicPort": 8443, "Type": "tcp"}]},
+	{"Names": ["/mqtt"], "Labels": {"lansrv.service": "mqtt", "lansrv.protocol": "mqtt"},
+	 "Ports": [{"PrivatePort": 1883, "PublicPort": 1883, "Type": "tcp"}]},
+	{"Names": ["/dns"], "Labels": {"lansrv.service": "dns", "lansrv.port": "53", "lansrv.transport": "udp"},
+	 "Ports": [], "HostConfig": {"NetworkMode": "host"}},
+	{"Names": ["/hidden"], "Labels": {"lansrv.service": "hidden", "lansrv.port": "9000"},
+	 "Ports": [{"PrivatePort": 9000, "Type": "tcp"}]},
+	{"Names": ["/db"], "Labels": {}, "Ports": [{"PrivatePort": 5432, "PublicPort": 5432, "Type": "tcp"}]}
+]`
+
+// fakeDockerAPI serves canned containers and streams one event for each value sent on the
+// returned channel.
+func fakeDockerAPI(t *testing.T) (string, chan<- string) {
+	socket := filepath.Join(t.TempDir(), "docker.sock")
+	return socket, fakeDockerAPIAt(t, socket)
+}
+
+// fakeDockerAPIAt starts fakeDockerAPI on @arg socket.
+func fakeDockerAPIAt(t *testing.T, socket string) chan<- string {
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events := make(chan string)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/containers/json", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, fakeContainers)
+	})
+	mux.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		for {
+			select {
+			case status := <-events:
+				fmt.Fprintf(w, `{"Type": "container", "status": %q}`+"\n", status)
+				w.(http.Flusher).Flush()
+			case <-r.Context().Done():
+				return
+			}
+		}
+	})
+
+	server := httptest.NewUnstartedServer(mux)
+	server.Listener = listener
+	server.Start()
+	t.Cleanup(server.Close)
+
+	return events
+}
+
+func TestDockerContainerAds(t *testing.T) {
+	socket, _ := fakeDockerAPI(t)
+
+	ads, err := NewDockerClient(socket).ContainerAds(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []LanAd{
+		{Service: "web", Port: 8080, Path: "/app", Protocol: "http"},
+		{Service: "mqtt", Port: 1883, Protocol: "mqtt"},
+		{Service: "dns", Port: 53, Protocol: "http", Transport: "udp"},
+	}, ads)
+}
+
+func TestDockerEvents(t *testing.T) {
+	socket, events := fakeDockerAPI(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	changes, err := NewDockerClient(socket).Events(ctx)
+	assert.NoError(t, err)
+
+	events <- "start"
+	select {
+	case <-changes:
+	case <-time.After(time.Second):
+		t.Fatal("no change after a container started")
+	}
+
+	cancel()
+	select {
+	case _, open := <-changes:
+		assert.False(t, open)
+	case <-time.After(time.Second):
+		t.Fatal("changes not closed after cancel")
+	}
+}
+
+func TestDockerSourceWaitsForDocker(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "docker.sock")
+	source := &DockerSource{Client: NewDockerClient(socket), Retry: 10 * time.Millisecond}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	updates := source.Watch(ctx)
+
+	time.Sleep(50 * time.Millisecond)
+	events := fakeDockerAPIAt(t, socket)
+
+	select {
+	case ads := <-updates:
+		assert.Len(t, ads, 3)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no containers after docker started")
+	}
+
+	events <- "start"
+	select {
+	case ads := <-updates:
+		assert.Len(t, ads, 3)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no update after a container started")
+	}
+}
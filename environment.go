@@ -0,0 +1,129 @@
+package lansrv
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Environment returns the variables the unit's processes are started with as set by Environment=
+// and EnvironmentFile= in the unit's own section e.g. [Service].  Like systemd, variables read from
+// files override those set with Environment= and a file prefixed with `-` may be missing.
+func (unit *Unit) Environment() (map[string]string, error) {
+	env := make(map[string]string)
+	section := unit.typeSection()
+
+	for _, assignments := range unit.Values(section, "Environment") {
+		for _, assignment := range splitQuoted(unit.ExpandSpecifiers(assignments)) {
+			if eq := strings.Index(assignment, "="); eq > 0 {
+				env[assignment[:eq]] = assignment[eq+1:]
+			}
+		}
+	}
+
+	for _, path := range unit.Values(section, "EnvironmentFile") {
+		optional := strings.HasPrefix(path, "-")
+		path = unit.ExpandSpecifiers(strings.TrimPrefix(path, "-"))
+
+		if err := readEnvironmentFile(path, env); err != nil {
+			if optional && os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+	}
+
+	return env, nil
+}
+
+// ExpandEnvironment replaces ${VAR} and $VAR references in @arg value with the unit's environment.
+// Unknown variables expand to an empty string.
+func ExpandEnvironment(value string, env map[string]string) string {
+	if !strings.Contains(value, "$") {
+		return value
+	}
+
+	return os.Expand(value, func(key string) string {
+		return env[key]
+	})
+}
+
+// typeSection returns the section holding the settings specific to the unit's type e.g. [Service]
+// for a .service unit.
+func (unit *Unit) typeSection() string {
+	unitType := strings.TrimPrefix(filepath.Ext(unit.Name), ".")
+	if len(unitType) == 0 {
+		return ""
+	}
+
+	return strings.ToUpper(unitType[:1]) + unitType[1:]
+}
+
+func readEnvironmentFile(path string, env map[string]string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+
+		eq := strings.Index(line, "=")
+		if eq <= 0 {
+			continue
+		}
+
+		key := strings.TrimSpace(strings.TrimPrefix(line[:eq], "export "))
+		values := splitQuoted(strings.TrimSpace(line[eq+1:]))
+		env[key] = strings.Join(values, " ")
+	}
+
+	return scanner.Err()
+}
+
+// splitQuoted splits @arg line on whitespace, keeping text in single or double quotes together and
+// honoring backslash escapes.
+func splitQuoted(line string) []string {
+	words := []string{}
+	word := &strings.Builder{}
+	inWord := false
+	var quote byte
+
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == '\\' && i+1 < len(line) && quote != '\'':
+			i++
+			word.WriteByte(line[i])
+			inWord = true
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+			word.WriteByte(c)
+		case c == '"' || c == '\'':
+			quote = c
+			inWord = true
+		case c == ' ' || c == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+
+	if inWord {
+		words = append(words, word.String())
+	}
+
+	return words
+}
@@ -0,0 +1,130 @@
+package lansrv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Forwarder forwards the TCP connections it accepts to the instances of Service from its latest
+// update, round robin.  When an instance can't be dialed the connection fails over to the next
+// one, and connections to instances that disappear are closed so their clients reconnect to one
+// that's still there.
+type Forwarder struct {
+	*balancer
+	Service     string
+	DialTimeout time.Duration
+
+	mutex sync.Mutex
+	conns map[connPair]string
+}
+
+// connPair is a forwarded connection.
+type connPair struct {
+	client, server net.Conn
+}
+
+// NewForwarder returns a forwarder to @arg service with no instances until its first update.
+func NewForwarder(service string) *Forwarder {
+	return &Forwarder{balancer: newBalancer(), Service: service, DialTimeout: 5 * time.Second, conns: make(map[connPair]string)}
+}
+
+func (forwarder *Forwarder) Update(services ServiceSet, events []ServiceEvent) error {
+	forwarder.balancer.Update(services, events)
+
+	removed := make(map[string]bool)
+	for _, event := range events {
+		if event.Type == Removed && event.Ad.Service == forwarder.Service {
+			removed[instanceAddress(event.Host, event.Ad)] = true
+		}
+	}
+
+	forwarder.mutex.Lock()
+	defer forwarder.mutex.Unlock()
+	for pair, address := range forwarder.conns {
+		if removed[address] {
+			pair.client.Close()
+			pair.server.Close()
+		}
+	}
+
+	return nil
+}
+
+// Serve forwards the connections accepted on @arg listener until it is closed.
+func (forwarder *Forwarder) Serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go forwarder.forward(conn)
+	}
+}
+
+func (forwarder *Forwarder) forward(client net.Conn) {
+	defer client.Close()
+
+	dialer := &net.Dialer{Timeout: forwarder.DialTimeout}
+	server, address, err := forwarder.dial(context.Background(), dialer, "tcp", forwarder.Service)
+	if err != nil {
+		fmt.Println("Failed to forward connection:", err)
+		return
+	}
+	defer server.Close()
+
+	pair := connPair{client: client, server: server}
+	forwarder.mutex.Lock()
+	forwarder.conns[pair] = address
+	forwarder.mutex.Unlock()
+	defer func() {
+		forwarder.mutex.Lock()
+		delete(forwarder.conns, pair)
+		forwarder.mutex.Unlock()
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		io.Copy(server, client)
+		closeWrite(server)
+		close(done)
+	}()
+	io.Copy(client, server)
+	closeWrite(client)
+	<-done
+}
+
+// closeWrite signals the end of the data to the other side of @arg conn, or closes it when it
+// can't be half closed.
+func closeWrite(conn net.Conn) {
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		tcp.CloseWrite()
+		return
+	}
+	conn.Close()
+}
+
+// newForwarder takes the address to `listen` on and the `service` to forward to.
+func newForwarder(options map[string]string) (Sink, error) {
+	if len(options["listen"]) == 0 || len(options["service"]) == 0 {
+		return nil, errors.New("listen and service are required")
+	}
+
+	listener, err := net.Listen("tcp", options["listen"])
+	if err != nil {
+		return nil, err
+	}
+
+	forwarder := NewForwarder(options["service"])
+	go func() {
+		if err := forwarder.Serve(listener); err != nil {
+			fmt.Println("Forwarder stopped:", err)
+		}
+	}()
+
+	return forwarder, nil
+}
@@ -0,0 +1,105 @@
+package lansrv
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// echoServer returns an ad for a TCP server echoing lines prefixed with @arg name.
+func echoServer(t *testing.T, name string) LanAd {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				for {
+					line, err := reader.ReadString('\n')
+					if err != nil {
+						return
+					}
+					conn.Write([]byte(name + " " + line))
+				}
+			}()
+		}
+	}()
+
+	return LanAd{Service: "nats-node", Address: net.ParseIP("127.0.0.1"), Port: listener.Addr().(*net.TCPAddr).Port, Protocol: "nats"}
+}
+
+func startForwarder(t *testing.T, forwarder *Forwarder) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go forwarder.Serve(listener)
+
+	return listener.Addr().String()
+}
+
+func sendLine(t *testing.T, conn net.Conn, line string) (string, error) {
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write([]byte(line + "\n")); err != nil {
+		return "", err
+	}
+	return bufio.NewReader(conn).ReadString('\n')
+}
+
+func TestForwarderFailsOver(t *testing.T) {
+	live := echoServer(t, "a")
+	forwarder := NewForwarder("nats-node")
+	forwarder.Update(ServiceSet{"a": {live}, "b": {deadAd(t, "nats-node")}}, nil)
+	address := startForwarder(t, forwarder)
+
+	for i := 0; i < 3; i++ {
+		conn, err := net.Dial("tcp", address)
+		assert.NoError(t, err)
+		reply, err := sendLine(t, conn, "ping")
+		assert.NoError(t, err)
+		assert.Equal(t, "a ping\n", reply)
+		conn.Close()
+	}
+}
+
+func TestForwarderRebalances(t *testing.T) {
+	a, b := echoServer(t, "a"), echoServer(t, "b")
+	forwarder := NewForwarder("nats-node")
+	forwarder.Update(ServiceSet{"a": {a}}, nil)
+	address := startForwarder(t, forwarder)
+
+	conn, err := net.Dial("tcp", address)
+	assert.NoError(t, err)
+	defer conn.Close()
+	reply, _ := sendLine(t, conn, "ping")
+	assert.Equal(t, "a ping\n", reply)
+
+	forwarder.Update(ServiceSet{"b": {b}}, []ServiceEvent{{Type: Removed, Host: "a", Ad: a}, {Type: Added, Host: "b", Ad: b}})
+	_, err = sendLine(t, conn, "ping")
+	assert.Error(t, err)
+
+	conn, err = net.Dial("tcp", address)
+	assert.NoError(t, err)
+	defer conn.Close()
+	reply, _ = sendLine(t, conn, "ping")
+	assert.Equal(t, "b ping\n", reply)
+}
+
+func TestForwarderWithoutInstances(t *testing.T) {
+	address := startForwarder(t, NewForwarder("nats-node"))
+
+	conn, err := net.Dial("tcp", address)
+	assert.NoError(t, err)
+	defer conn.Close()
+	_, err = sendLine(t, conn, "ping")
+	assert.Error(t, err)
+}
@@ -4,7 +4,11 @@ go 1.15
 
 require (
 	github.com/AsynkronIT/protoactor-go v0.0.0-20201101183904-ac049136938d
+	github.com/cenkalti/backoff v2.2.1+incompatible
 	github.com/grandcat/zeroconf v1.0.0
+	github.com/miekg/dns v1.1.27
+	github.com/miekg/dns v1.1.27
 	github.com/stretchr/testify v1.6.1
-	github.com/zieckey/goini v0.0.0-20180118150432-0da17d361d26
+	golang.org/x/net v0.0.0-20200114155413-6afb5195e5aa
+	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c
 )
@@ -6,28 +6,52 @@ import (
 	"errors"
 	"fmt"
 	"net"
-	"os"
 	"strconv"
 	"strings"
+	"sync"
+	"unicode"
 
 	"github.com/grandcat/zeroconf"
 )
 
 var Service = "LanSrv"
 
-const (
-	domain = "local"
-)
+// Instance is the mDNS instance name the server registers under.  The hostname is used when it is
+// empty.
+var Instance = ""
+
+// Domain is the mDNS domain services are registered and browsed in.
+var Domain = "local"
+
+// Interfaces limits the server and lookups to these network interfaces.  All multicast capable
+// interfaces are used when it is empty.
+var Interfaces []net.Interface
+
+// TTL overrides the time to live in seconds of the records the server publishes when it is set.
+var TTL uint32
 
 type LanAd struct {
-	Service  string
-	Address  net.IP `json:"-"`
-	Port     int
-	Path     string
-	Protocol string
+	Service   string
+	Address   net.IP `json:"-"`
+	Port      int
+	Path      string
+	Protocol  string
+	Transport string `json:",omitempty"`
+	// Tags are free form labels for consumers to select ads by, e.g. `metrics`.
+	Tags []string `json:",omitempty"`
+	// User is set for services run by a user's systemd instance.
+	User string `json:",omitempty"`
+	// SignedAt and Signature are only set on the wire, see SigningKey.
+	SignedAt  int64  `json:"Ts,omitempty"`
+	Signature string `json:"Sig,omitempty"`
 }
 
+// FromMap fills the ad from a LanSrv section.  The service name may be given as either Service or
+// Name and Tags as a list delimited by commas or spaces.
 func (ad *LanAd) FromMap(adMap map[string]string) error {
+	if name, ok := adMap["Name"]; ok {
+		ad.Service = name
+	}
 	if name, ok := adMap["Service"]; ok {
 		ad.Service = name
 	}
@@ -51,6 +75,14 @@ func (ad *LanAd) FromMap(adMap map[string]string) error {
 		ad.Protocol = "http"
 	}
 
+	if transport, ok := adMap["Transport"]; ok {
+		ad.Transport = transport
+	}
+
+	if tags, ok := adMap["Tags"]; ok {
+		ad.Tags = strings.FieldsFunc(tags, func(r rune) bool { return r == ',' || unicode.IsSpace(r) })
+	}
+
 	return nil
 }
 
@@ -127,32 +159,65 @@ func (ad *LanAd) ToFormattedString(format string) string {
 }
 
 func (ad *LanAd) EqualTo(other *LanAd) bool {
-	return ad.Service == other.Service && ad.Port == other.Port && ad.Protocol == other.Protocol && ad.Address.String() == other.Address.String()
+	return ad.Service == other.Service && ad.Port == other.Port && ad.Protocol == other.Protocol && ad.transport() == other.transport() &&
+		ad.Address.String() == other.Address.String()
+}
+
+// transport returns the ad's transport, which is tcp unless set otherwise.
+func (ad *LanAd) transport() string {
+	if len(ad.Transport) == 0 {
+		return "tcp"
+	}
+
+	return ad.Transport
 }
 
 func StartMdnsServer(ads []LanAd, port int) (*zeroconf.Server, error) {
-	host, _ := os.Hostname()
+	server, err := zeroconf.Register(instanceName(), Service, Domain, port, AdRecords(ads), Interfaces)
+	if err == nil && TTL > 0 {
+		server.TTL(TTL)
+	}
+	if err == nil {
+		recordPublished(ads)
+	}
+
+	return server, err
+}
+
+// UpdateMdnsServer replaces the ads published by @arg server.
+func UpdateMdnsServer(server *zeroconf.Server, ads []LanAd) {
+	server.SetText(AdRecords(ads))
+	recordPublished(ads)
+}
 
+// AdRecords encodes @arg ads as the TXT records the server publishes, signing them for the
+// server's instance when a SigningKey is set.
+func AdRecords(ads []LanAd) []string {
 	records := make([]string, len(ads))
 	for i, ad := range ads {
+		if len(SigningKey) > 0 {
+			ad = SignAd(ad, instanceName())
+		}
 		data, _ := json.Marshal(ad)
 		records[i] = string(data)
 	}
 
-	return zeroconf.Register(host, Service, domain, port, records, nil)
+	return records
 }
 
 // ServicesLookup returns a map containing hostnames along with a list of LanAds published
 // on that host.
 func ServicesLookup(ctx context.Context, localhost bool) (map[string][]LanAd, error) {
 	// Discover all services on the network (e.g. _workstation._tcp)
-	resolver, err := zeroconf.NewResolver()
+	resolver, err := zeroconf.NewResolver(zeroconf.SelectIfaces(Interfaces))
 	if err != nil {
 		return nil, errors.New(fmt.Sprint("Failed to initialize resolver:", err.Error()))
 	}
 
 	entries := make(chan *zeroconf.ServiceEntry)
 	ads := make(map[string][]LanAd)
+	// the resolver doesn't close entries, so results are copied out under the lock
+	var mutex sync.Mutex
 	localIPs := make(map[string]interface{})
 	if !localhost {
 		localIPs = hostIPs()
@@ -168,6 +233,7 @@ func ServicesLookup(ctx context.Context, localhost bool) (map[string][]LanAd, er
 				continue
 			}
 
+			mutex.Lock()
 			if _, ok := store[host]; !ok {
 				store[host] = make([]LanAd, 0)
 			}
@@ -179,6 +245,12 @@ func ServicesLookup(ctx context.Context, localhost bool) (map[string][]LanAd, er
 				if err := json.Unmarshal([]byte(adData), &ad); err != nil {
 					fmt.Println("could not parse ad:", err)
 					fmt.Println("adData:", adData)
+					parseFailures.add(1)
+					continue
+				}
+				if len(SigningKey) > 0 && !VerifyAd(&ad, entry.Instance) {
+					fmt.Println("dropping ad with invalid signature from", host+":", ad.Service)
+					signatureFailures.add(1)
 					continue
 				}
 
@@ -190,16 +262,26 @@ func ServicesLookup(ctx context.Context, localhost bool) (map[string][]LanAd, er
 
 				store[host] = append(store[host], ad)
 			}
+			mutex.Unlock()
 		}
 	}(entries, ads)
 
-	if err := resolver.Browse(ctx, Service, domain, entries); err != nil {
+	if err := resolver.Browse(ctx, Service, Domain, entries); err != nil {
 		return nil, err
 	}
 
 	<-ctx.Done()
 
-	return ads, nil
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	found := make(map[string][]LanAd, len(ads))
+	for host, hostAds := range ads {
+		found[host] = append([]LanAd{}, hostAds...)
+	}
+	recordScan(found)
+
+	return found, nil
 }
 
 // this is stupid but it will work
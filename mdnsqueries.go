@@ -0,0 +1,143 @@
+package lansrv
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"github.com/grandcat/zeroconf"
+	"github.com/miekg/dns"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// mdnsPort and the mDNS groups are where the server's multicast queries arrive.
+var (
+	mdnsPort      = 5353
+	mdnsGroupIPv4 = net.IPv4(224, 0, 0, 251)
+	mdnsGroupIPv6 = net.ParseIP("ff02::fb")
+)
+
+// defaultMdnsTTL is the TTL of the records the mDNS library publishes when TTL isn't set.
+const defaultMdnsTTL = 3200
+
+// CountMdnsQueries counts the multicast queries the server published by StartMdnsServer answers
+// until @arg ctx is done.  The mDNS library doesn't report them, so the queries are received on a
+// socket of their own sharing the mDNS port, joined to the groups on Interfaces.
+func CountMdnsQueries(ctx context.Context) error {
+	conns := []net.PacketConn{}
+	if conn, err := joinMdnsGroup("udp4", mdnsGroupIPv4); err == nil {
+		conns = append(conns, conn)
+	}
+	if conn, err := joinMdnsGroup("udp6", mdnsGroupIPv6); err == nil {
+		conns = append(conns, conn)
+	}
+	if len(conns) == 0 {
+		return errors.New("could not join the mDNS groups")
+	}
+
+	record := zeroconf.NewServiceRecord(instanceName(), Service, Domain)
+	for _, conn := range conns {
+		go func(conn net.PacketConn) {
+			<-ctx.Done()
+			conn.Close()
+		}(conn)
+
+		go func(conn net.PacketConn) {
+			buf := make([]byte, 65536)
+			for {
+				n, _, err := conn.ReadFrom(buf)
+				if err != nil {
+					return
+				}
+				if answersQuery(record, buf[:n]) {
+					mdnsQueries.add(1)
+				}
+			}
+		}(conn)
+	}
+
+	return nil
+}
+
+// joinMdnsGroup listens on the mDNS port of @arg group, joined on Interfaces or on every
+// multicast interface when there are none.
+func joinMdnsGroup(network string, group net.IP) (net.PacketConn, error) {
+	conn, err := net.ListenUDP(network, &net.UDPAddr{IP: group, Port: mdnsPort})
+	if err != nil {
+		return nil, err
+	}
+
+	interfaces := Interfaces
+	if len(interfaces) == 0 {
+		all, _ := net.Interfaces()
+		for _, iface := range all {
+			if iface.Flags&net.FlagUp != 0 && iface.Flags&net.FlagMulticast != 0 {
+				interfaces = append(interfaces, iface)
+			}
+		}
+	}
+
+	joined := 0
+	for i := range interfaces {
+		var err error
+		if network == "udp4" {
+			err = ipv4.NewPacketConn(conn).JoinGroup(&interfaces[i], &net.UDPAddr{IP: group})
+		} else {
+			err = ipv6.NewPacketConn(conn).JoinGroup(&interfaces[i], &net.UDPAddr{IP: group})
+		}
+		if err == nil {
+			joined++
+		}
+	}
+	if joined == 0 {
+		conn.Close()
+		return nil, errors.New("no interface joined " + group.String())
+	}
+
+	return conn, nil
+}
+
+// answersQuery reports whether the server publishing @arg record answers @arg packet: a query
+// with a question for the service type, the service or the instance, the way the mDNS library
+// decides it, including leaving out browsing answers the querier says it knows.
+func answersQuery(record *zeroconf.ServiceRecord, packet []byte) bool {
+	var query dns.Msg
+	if err := query.Unpack(packet); err != nil || query.Response || len(query.Ns) > 0 {
+		return false
+	}
+
+	for _, question := range query.Question {
+		switch question.Name {
+		case record.ServiceTypeName():
+			if !knownAnswer(&query, record.ServiceName()) {
+				return true
+			}
+		case record.ServiceName():
+			if !knownAnswer(&query, record.ServiceInstanceName()) {
+				return true
+			}
+		case record.ServiceInstanceName():
+			return true
+		}
+	}
+
+	return false
+}
+
+// knownAnswer reports whether @arg query lists a pointer to @arg name that is still fresh enough
+// for the mDNS library to leave out of its answer.
+func knownAnswer(query *dns.Msg, name string) bool {
+	ttl := TTL
+	if ttl == 0 {
+		ttl = defaultMdnsTTL
+	}
+
+	for _, answer := range query.Answer {
+		if ptr, ok := answer.(*dns.PTR); ok && ptr.Ptr == name && ptr.Hdr.Ttl >= ttl/2 {
+			return true
+		}
+	}
+
+	return false
+}
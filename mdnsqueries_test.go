@@ -0,0 +1,41 @@
+package lansrv
+
+import (
+	"testing"
+
+	"github.com/grandcat/zeroconf"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAnswersQuery(t *testing.T) {
+	record := zeroconf.NewServiceRecord("node1", Service, Domain)
+	query := func(name string, known ...dns.RR) []byte {
+		msg := new(dns.Msg)
+		msg.SetQuestion(name, dns.TypePTR)
+		msg.Answer = known
+		packet, err := msg.Pack()
+		assert.NoError(t, err)
+		return packet
+	}
+	ptr := func(name string, ttl uint32) dns.RR {
+		return &dns.PTR{Hdr: dns.RR_Header{Name: record.ServiceName(), Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: ttl}, Ptr: name}
+	}
+
+	assert.True(t, answersQuery(record, query(record.ServiceName())))
+	assert.True(t, answersQuery(record, query(record.ServiceInstanceName())))
+	assert.True(t, answersQuery(record, query(record.ServiceTypeName())))
+	assert.False(t, answersQuery(record, query("_printer._tcp.local.")))
+	assert.False(t, answersQuery(record, []byte("not dns")))
+
+	// known answers that are still fresh aren't repeated
+	assert.False(t, answersQuery(record, query(record.ServiceName(), ptr(record.ServiceInstanceName(), defaultMdnsTTL))))
+	assert.True(t, answersQuery(record, query(record.ServiceName(), ptr(record.ServiceInstanceName(), 10))))
+	assert.True(t, answersQuery(record, query(record.ServiceName(), ptr("node2."+record.ServiceName(), defaultMdnsTTL))))
+
+	response := new(dns.Msg)
+	response.SetQuestion(record.ServiceName(), dns.TypePTR)
+	response.Response = true
+	packet, _ := response.Pack()
+	assert.False(t, answersQuery(record, packet))
+}
@@ -0,0 +1,142 @@
+package lansrv
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metric is a Prometheus counter or gauge, optionally split by the values of a single label.
+type metric struct {
+	name, help, kind string
+	label            string
+
+	mutex  sync.Mutex
+	values map[string]float64
+}
+
+// Metrics of the daemon, see WriteMetrics.
+var (
+	adsAdvertised = &metric{name: "lansrv_ads_advertised", kind: "gauge",
+		help: "Number of ads published by this node."}
+	hostsDiscovered = &metric{name: "lansrv_hosts_discovered", kind: "gauge",
+		help: "Number of hosts with ads found by the last scan."}
+	serviceAds = &metric{name: "lansrv_service_ads", kind: "gauge", label: "service",
+		help: "Number of ads for each service found by the last scan."}
+	scans = &metric{name: "lansrv_scans_total", kind: "counter",
+		help: "Number of scans of the network."}
+	parseFailures = &metric{name: "lansrv_ad_parse_failures_total", kind: "counter",
+		help: "Number of discovered ads that could not be parsed."}
+	signatureFailures = &metric{name: "lansrv_ad_signature_failures_total", kind: "counter",
+		help: "Number of discovered ads dropped for an invalid signature."}
+	reloads = &metric{name: "lansrv_reloads_total", kind: "counter",
+		help: "Number of times the ads were reloaded, on SIGHUP or when a source changed."}
+	lastReload = &metric{name: "lansrv_last_reload_timestamp_seconds", kind: "gauge",
+		help: "Time the ads were last loaded or reloaded."}
+
+	mdnsQueries = &metric{name: "lansrv_mdns_queries_answered_total", kind: "counter",
+		help: "Number of multicast mDNS queries answered by the server, see CountMdnsQueries."}
+
+	allMetrics = []*metric{
+		adsAdvertised, hostsDiscovered, serviceAds, scans, parseFailures, signatureFailures, reloads, lastReload, mdnsQueries,
+	}
+)
+
+func (m *metric) add(delta float64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.values == nil {
+		m.values = make(map[string]float64)
+	}
+	m.values[""] += delta
+}
+
+func (m *metric) set(value float64) {
+	m.setAll(map[string]float64{"": value})
+}
+
+// setAll replaces the values of a labeled metric with @arg values by label value.
+func (m *metric) setAll(values map[string]float64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.values = values
+}
+
+func (m *metric) write(w *bufio.Writer) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	w.WriteString("# HELP " + m.name + " " + m.help + "\n# TYPE " + m.name + " " + m.kind + "\n")
+	if len(m.label) == 0 {
+		w.WriteString(m.name + " " + strconv.FormatFloat(m.values[""], 'g', -1, 64) + "\n")
+		return
+	}
+
+	labels := make([]string, 0, len(m.values))
+	for label := range m.values {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+
+	escaper := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	for _, label := range labels {
+		w.WriteString(m.name + "{" + m.label + `="` + escaper.Replace(label) + `"} ` +
+			strconv.FormatFloat(m.values[label], 'g', -1, 64) + "\n")
+	}
+}
+
+// recordPublished updates the metrics for a newly published set of @arg ads.
+func recordPublished(ads []LanAd) {
+	adsAdvertised.set(float64(len(ads)))
+}
+
+// recordLoad updates the metrics after the ads were loaded, counting it when @arg reload says it
+// wasn't the first load.
+func recordLoad(reload bool) {
+	lastReload.set(float64(time.Now().Unix()))
+	if reload {
+		reloads.add(1)
+	}
+}
+
+// recordScan updates the metrics with the result of a scan.
+func recordScan(services ServiceSet) {
+	hosts, perService := 0, make(map[string]float64)
+	for _, ads := range services {
+		if len(ads) > 0 {
+			hosts++
+		}
+		for _, ad := range ads {
+			perService[ad.Service]++
+		}
+	}
+
+	scans.add(1)
+	hostsDiscovered.set(float64(hosts))
+	serviceAds.setAll(perService)
+}
+
+// WriteMetrics writes the daemon's metrics to @arg w in the Prometheus text exposition format.
+func WriteMetrics(w io.Writer) error {
+	buffered := bufio.NewWriter(w)
+	for _, m := range allMetrics {
+		m.write(buffered)
+	}
+
+	return buffered.Flush()
+}
+
+// MetricsHandler serves WriteMetrics.
+func MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		WriteMetrics(w)
+	})
+}
@@ -0,0 +1,68 @@
+package lansrv
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	recordPublished([]LanAd{{Service: "files"}, {Service: "nats-node"}})
+	recordLoad(false)
+	recordScan(ServiceSet{
+		"10.0.0.2": {{Service: "nats-node"}},
+		"10.0.0.3": {{Service: "nats-node"}, {Service: `odd"name`}},
+		"10.0.0.4": {},
+	})
+
+	var buf bytes.Buffer
+	assert.NoError(t, WriteMetrics(&buf))
+	text := buf.String()
+
+	for _, line := range []string{
+		"# TYPE lansrv_ads_advertised gauge",
+		"lansrv_ads_advertised 2",
+		"lansrv_hosts_discovered 2",
+		`lansrv_service_ads{service="nats-node"} 2`,
+		`lansrv_service_ads{service="odd\"name"} 1`,
+		"# TYPE lansrv_scans_total counter",
+	} {
+		assert.Contains(t, strings.Split(text, "\n"), line)
+	}
+	assert.NotContains(t, text, "lansrv_last_reload_timestamp_seconds 0\n")
+
+	recordScan(ServiceSet{})
+	buf.Reset()
+	WriteMetrics(&buf)
+	assert.NotContains(t, buf.String(), "lansrv_service_ads{")
+}
+
+func TestMetricsHandler(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	MetricsHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+
+	assert.Equal(t, "text/plain; version=0.0.4", recorder.Header().Get("Content-Type"))
+	assert.Contains(t, recorder.Body.String(), "# HELP lansrv_reloads_total ")
+}
+
+func TestReloadMetrics(t *testing.T) {
+	count := func() float64 {
+		reloads.mutex.Lock()
+		defer reloads.mutex.Unlock()
+		return reloads.values[""]
+	}
+
+	merged := &MergedSource{Sources: []AdSource{NewFlagSource([]string{"http://files:9999"})}}
+	_, err := merged.Load(context.Background())
+	assert.NoError(t, err)
+	before := count()
+
+	// reloads count even when they find the same ads
+	assert.NoError(t, merged.Reload(context.Background()))
+	assert.NoError(t, merged.Reload(context.Background()))
+	assert.Equal(t, before+2, count())
+}
@@ -0,0 +1,412 @@
+package lansrv
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MQTT 3.1.1 control packet types, shifted into the high nibble of the fixed header.
+const (
+	mqttConnect    = 1 << 4
+	mqttConnack    = 2 << 4
+	mqttPublish    = 3 << 4
+	mqttPingreq    = 12 << 4
+	mqttDisconnect = 14 << 4
+
+	mqttRetain = 1
+)
+
+// MqttConn is a minimal MQTT 3.1.1 client that publishes at QoS 0, which is all lansrv needs.
+type MqttConn struct {
+	conn   net.Conn
+	writes sync.Mutex
+	closed chan struct{}
+}
+
+// DialMqtt connects to the broker at @arg address, either `host:port` or a
+// `mqtt://[user:password@]host:port` URL, as @arg clientID.  The connection is kept alive with
+// pings every @arg keepAlive.
+func DialMqtt(ctx context.Context, address, clientID string, keepAlive time.Duration) (*MqttConn, error) {
+	username, password := "", ""
+	hasUser, hasPassword := false, false
+
+	if strings.Contains(address, "://") {
+		mqttURL, err := url.Parse(address)
+		if err != nil {
+			return nil, err
+		}
+		address = mqttURL.Host
+		if mqttURL.Port() == "" {
+			address = net.JoinHostPort(mqttURL.Hostname(), "1883")
+		}
+		if mqttURL.User != nil {
+			username, hasUser = mqttURL.User.Username(), true
+			password, hasPassword = mqttURL.User.Password()
+		}
+	}
+
+	dialer := new(net.Dialer)
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(5 * time.Second)
+	}
+	conn.SetDeadline(deadline)
+
+	flags := byte(0x02) // clean session
+	payload := mqttString(clientID)
+	if hasUser {
+		flags |= 0x80
+		payload = append(payload, mqttString(username)...)
+	}
+	if hasPassword {
+		flags |= 0x40
+		payload = append(payload, mqttString(password)...)
+	}
+
+	seconds := uint16(keepAlive / time.Second)
+	variable := append(mqttString("MQTT"), 4, flags, byte(seconds>>8), byte(seconds))
+	if _, err := conn.Write(mqttPacket(mqttConnect, append(variable, payload...))); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	header, body, err := readMqttPacket(reader)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if header&0xf0 != mqttConnack || len(body) != 2 {
+		conn.Close()
+		return nil, errors.New("unexpected reply to MQTT connect")
+	}
+	if body[1] != 0 {
+		conn.Close()
+		return nil, fmt.Errorf("MQTT broker refused the connection with code %d", body[1])
+	}
+	conn.SetDeadline(time.Time{})
+
+	mqtt := &MqttConn{conn: conn, closed: make(chan struct{})}
+	go mqtt.read(reader)
+	if keepAlive > 0 {
+		go mqtt.ping(keepAlive / 2)
+	}
+
+	return mqtt, nil
+}
+
+// Publish sends @arg payload to @arg topic, which the broker keeps for new subscribers when
+// @arg retain is set.  An empty retained payload clears the topic.
+func (mqtt *MqttConn) Publish(topic string, payload []byte, retain bool) error {
+	header := byte(mqttPublish)
+	if retain {
+		header |= mqttRetain
+	}
+
+	return mqtt.write(mqttPacket(header, append(mqttString(topic), payload...)))
+}
+
+// Done is closed once the connection is lost or closed.
+func (mqtt *MqttConn) Done() <-chan struct{} {
+	return mqtt.closed
+}
+
+// Close disconnects from the broker.
+func (mqtt *MqttConn) Close() error {
+	mqtt.write(mqttPacket(mqttDisconnect, nil))
+	return mqtt.conn.Close()
+}
+
+func (mqtt *MqttConn) write(packet []byte) error {
+	mqtt.writes.Lock()
+	defer mqtt.writes.Unlock()
+
+	_, err := mqtt.conn.Write(packet)
+	return err
+}
+
+// read discards the broker's ping responses until the connection fails.
+func (mqtt *MqttConn) read(reader *bufio.Reader) {
+	defer close(mqtt.closed)
+	defer mqtt.conn.Close()
+
+	for {
+		if _, _, err := readMqttPacket(reader); err != nil {
+			return
+		}
+	}
+}
+
+func (mqtt *MqttConn) ping(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := mqtt.write(mqttPacket(mqttPingreq, nil)); err != nil {
+				return
+			}
+		case <-mqtt.closed:
+			return
+		}
+	}
+}
+
+// mqttPacket frames @arg body with a fixed header of @arg header and its remaining length.
+func mqttPacket(header byte, body []byte) []byte {
+	packet := []byte{header}
+	length := len(body)
+	for {
+		digit := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			digit |= 0x80
+		}
+		packet = append(packet, digit)
+		if length == 0 {
+			break
+		}
+	}
+
+	return append(packet, body...)
+}
+
+func readMqttPacket(reader *bufio.Reader) (byte, []byte, error) {
+	header, err := reader.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	length, multiplier := 0, 1
+	for i := 0; ; i++ {
+		digit, err := reader.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		if i == 4 {
+			return 0, nil, errors.New("invalid MQTT packet length")
+		}
+		length += int(digit&0x7f) * multiplier
+		multiplier *= 128
+		if digit&0x80 == 0 {
+			break
+		}
+	}
+
+	body := make([]byte, length)
+	_, err = io.ReadFull(reader, body)
+	return header, body, err
+}
+
+func mqttString(value string) []byte {
+	return append([]byte{byte(len(value) >> 8), byte(len(value))}, value...)
+}
+
+// MqttSink keeps a retained topic `<prefix>/<name>/<host>` holding the ad JSON of every discovered
+// service on an MQTT broker and clears the topics of services that disappear.  When a host
+// advertises a service on several ports the topic holds the ad with the lowest port.  `/`, `+`
+// and `#` in names are replaced by underscores.  Lost connections are re-established in the
+// background every Retry.
+type MqttSink struct {
+	Address   string
+	Prefix    string
+	ClientID  string
+	KeepAlive time.Duration
+	Retry     time.Duration
+
+	mutex     sync.Mutex
+	conn      *MqttConn
+	services  ServiceSet
+	published map[string]string
+	closed    bool
+}
+
+// NewMqttSink returns a sink publishing to the broker at @arg address under @arg prefix, which
+// defaults to `lansrv/services`.  It connects on the first update.  The client id names the host
+// and process, since a broker disconnects a client when another connects with the same id.
+func NewMqttSink(address, prefix string) *MqttSink {
+	if len(prefix) == 0 {
+		prefix = "lansrv/services"
+	}
+	host, _ := os.Hostname()
+
+	return &MqttSink{
+		Address:   address,
+		Prefix:    prefix,
+		ClientID:  "lansrv-" + host + "-" + strconv.Itoa(os.Getpid()),
+		KeepAlive: time.Minute,
+		Retry:     5 * time.Second,
+		published: make(map[string]string),
+	}
+}
+
+func (sink *MqttSink) Update(services ServiceSet, _ []ServiceEvent) error {
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+
+	sink.services = services
+	return sink.sync()
+}
+
+// sync publishes the topics that changed since they were last published, connecting first when
+// there is no connection.  It is called with the mutex held.
+func (sink *MqttSink) sync() error {
+	if sink.closed {
+		return errors.New("MQTT sink is closed")
+	}
+	if sink.conn != nil {
+		select {
+		case <-sink.conn.Done():
+			sink.conn = nil
+		default:
+		}
+	}
+	reconnected := false
+	if sink.conn == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		conn, err := DialMqtt(ctx, sink.Address, sink.ClientID, sink.KeepAlive)
+		if err != nil {
+			return err
+		}
+		// the broker may have lost the retained topics, so publish everything again
+		sink.conn, reconnected = conn, true
+		go sink.reconnect(conn)
+	}
+
+	topics := sink.topics(sink.services)
+	names := make([]string, 0, len(topics)+len(sink.published))
+	for topic := range topics {
+		names = append(names, topic)
+	}
+	for topic := range sink.published {
+		if _, ok := topics[topic]; !ok {
+			names = append(names, topic)
+		}
+	}
+	sort.Strings(names)
+
+	for _, topic := range names {
+		payload, current := topics[topic]
+		if published, ok := sink.published[topic]; !reconnected && ok == current && published == payload {
+			continue
+		}
+
+		if err := sink.conn.Publish(topic, []byte(payload), true); err != nil {
+			sink.conn.Close()
+			return err
+		}
+		if current {
+			sink.published[topic] = payload
+		} else {
+			delete(sink.published, topic)
+		}
+	}
+
+	return nil
+}
+
+// reconnect waits for @arg conn to be lost and connects again, publishing the current topics.
+func (sink *MqttSink) reconnect(conn *MqttConn) {
+	<-conn.Done()
+
+	for {
+		time.Sleep(sink.Retry)
+
+		sink.mutex.Lock()
+		if sink.closed || (sink.conn != nil && sink.conn != conn) {
+			// closed, or an update already connected again
+			sink.mutex.Unlock()
+			return
+		}
+		err := sink.sync()
+		sink.mutex.Unlock()
+
+		if err == nil {
+			return
+		}
+	}
+}
+
+// Close disconnects from the broker, leaving the retained topics in place, and stops reconnecting.
+func (sink *MqttSink) Close() error {
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+
+	sink.closed = true
+	if sink.conn == nil {
+		return nil
+	}
+	return sink.conn.Close()
+}
+
+// topics returns the retained payload of each topic for @arg services.
+func (sink *MqttSink) topics(services ServiceSet) map[string]string {
+	topics := make(map[string]string)
+	ports := make(map[string]int)
+	for host, ads := range services {
+		for _, ad := range ads {
+			topic := sink.Prefix + "/" + mqttTopicLevel(ad.Service) + "/" + mqttTopicLevel(host)
+			if port, ok := ports[topic]; ok && port <= ad.Port {
+				continue
+			}
+
+			data, _ := json.Marshal(ad)
+			topics[topic], ports[topic] = string(data), ad.Port
+		}
+	}
+
+	return topics
+}
+
+// mqttTopicLevel makes @arg name usable as a single topic level.
+func mqttTopicLevel(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '+', '#':
+			return '_'
+		}
+		return r
+	}, name)
+}
+
+// newMqttSink takes the broker `url`, the topic `prefix`, the `client` id and the `keepalive` in
+// seconds.
+func newMqttSink(options map[string]string) (Sink, error) {
+	if len(options["url"]) == 0 {
+		return nil, errors.New("url is required")
+	}
+
+	sink := NewMqttSink(options["url"], options["prefix"])
+	if client, ok := options["client"]; ok {
+		sink.ClientID = client
+	}
+	if keepAlive, ok := options["keepalive"]; ok {
+		seconds, err := strconv.Atoi(keepAlive)
+		if err != nil {
+			return nil, errors.New("invalid keepalive " + keepAlive)
+		}
+		sink.KeepAlive = time.Duration(seconds) * time.Second
+	}
+
+	return sink, nil
+}
@@ -0,0 +1,139 @@
+package lansrv
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type mqttMessage struct {
+	topic   string
+	payload string
+	retain  bool
+}
+
+// fakeBroker is a stand-in MQTT broker accepting one client at a time.
+type fakeBroker struct {
+	listener  net.Listener
+	conns     chan net.Conn
+	connects  chan []byte
+	published chan mqttMessage
+}
+
+func newFakeBroker(t *testing.T) *fakeBroker {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	broker := &fakeBroker{listener: listener, conns: make(chan net.Conn, 4), connects: make(chan []byte, 2), published: make(chan mqttMessage, 16)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			broker.serve(conn)
+		}
+	}()
+
+	return broker
+}
+
+func (broker *fakeBroker) serve(conn net.Conn) {
+	defer conn.Close()
+	broker.conns <- conn
+
+	reader := bufio.NewReader(conn)
+	for {
+		header, body, err := readMqttPacket(reader)
+		if err != nil {
+			return
+		}
+
+		switch header & 0xf0 {
+		case mqttConnect:
+			broker.connects <- body
+			conn.Write([]byte{mqttConnack, 2, 0, 0})
+		case mqttPublish:
+			length := int(body[0])<<8 | int(body[1])
+			broker.published <- mqttMessage{string(body[2 : 2+length]), string(body[2+length:]), header&mqttRetain != 0}
+		case mqttDisconnect:
+			return
+		}
+	}
+}
+
+func (broker *fakeBroker) receive(t *testing.T) mqttMessage {
+	select {
+	case msg := <-broker.published:
+		return msg
+	case <-time.After(2 * time.Second):
+		t.Fatal("nothing published")
+		return mqttMessage{}
+	}
+}
+
+func TestMqttSink(t *testing.T) {
+	broker := newFakeBroker(t)
+	sink, err := NewSink("mqtt:url=mqtt://ha:pw@" + broker.listener.Addr().String() + ",client=test")
+	assert.NoError(t, err)
+	defer sink.(*MqttSink).Close()
+
+	files := LanAd{Service: "files", Address: net.ParseIP("10.0.0.2"), Port: 9999, Protocol: "http"}
+	mqtt := LanAd{Service: "mqtt", Address: net.ParseIP("10.0.0.3"), Port: 1883, Protocol: "mqtt"}
+	assert.NoError(t, sink.Update(ServiceSet{"10.0.0.2": {files}, "10.0.0.3": {mqtt}}, nil))
+
+	connect := <-broker.connects
+	assert.Equal(t, "\x00\x04MQTT\x04\xc2\x00\x3c\x00\x04test\x00\x02ha\x00\x02pw", string(connect))
+
+	assert.Equal(t, mqttMessage{"lansrv/services/files/10.0.0.2",
+		`{"Service":"files","Port":9999,"Path":"","Protocol":"http"}`, true}, broker.receive(t))
+	assert.Equal(t, mqttMessage{"lansrv/services/mqtt/10.0.0.3",
+		`{"Service":"mqtt","Port":1883,"Path":"","Protocol":"mqtt"}`, true}, broker.receive(t))
+
+	// unchanged topics aren't published again and removed ones are cleared
+	assert.NoError(t, sink.Update(ServiceSet{"10.0.0.2": {files}}, nil))
+	assert.Equal(t, mqttMessage{"lansrv/services/mqtt/10.0.0.3", "", true}, broker.receive(t))
+
+	select {
+	case msg := <-broker.published:
+		t.Fatal("unexpected publish", msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestMqttSinkReconnect(t *testing.T) {
+	broker := newFakeBroker(t)
+	sink := NewMqttSink(broker.listener.Addr().String(), "")
+	sink.Retry = 10 * time.Millisecond
+	defer sink.Close()
+	assert.Contains(t, sink.ClientID, "-"+strconv.Itoa(os.Getpid()))
+
+	files := LanAd{Service: "files", Address: net.ParseIP("10.0.0.2"), Port: 9999, Protocol: "http"}
+	assert.NoError(t, sink.Update(ServiceSet{"10.0.0.2": {files}}, nil))
+	<-broker.connects
+	assert.Equal(t, "lansrv/services/files/10.0.0.2", broker.receive(t).topic)
+
+	(<-broker.conns).Close()
+
+	// the topics are published again without waiting for an update
+	<-broker.connects
+	assert.Equal(t, mqttMessage{"lansrv/services/files/10.0.0.2",
+		`{"Service":"files","Port":9999,"Path":"","Protocol":"http"}`, true}, broker.receive(t))
+}
+
+func TestMqttPacketLength(t *testing.T) {
+	packet := mqttPacket(mqttPublish, make([]byte, 321))
+	assert.Equal(t, []byte{mqttPublish, 0xc1, 0x02}, packet[:3])
+
+	header, body, err := readMqttPacket(bufio.NewReader(bytes.NewReader(packet)))
+	assert.NoError(t, err)
+	assert.Equal(t, byte(mqttPublish), header)
+	assert.Len(t, body, 321)
+}
@@ -0,0 +1,471 @@
+package lansrv
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NatsHandler receives the messages of a subscription.  @arg reply is empty unless the message is
+// a request.  It is called from the connection's read loop, so it must not block.
+type NatsHandler func(subject, reply string, data []byte)
+
+// NatsConn is a client for the plain-text NATS protocol supporting publishing and subscriptions,
+// which is all lansrv needs.
+type NatsConn struct {
+	conn   net.Conn
+	writes sync.Mutex
+
+	mutex    sync.Mutex
+	handlers map[string]NatsHandler
+	nextSid  int
+	err      error
+
+	pongs  chan struct{}
+	closed chan struct{}
+}
+
+// DialNats connects to the NATS server at @arg address, either `host:port` or a
+// `nats://[user:password@|token@]host:port` URL.
+func DialNats(ctx context.Context, address string) (*NatsConn, error) {
+	options := map[string]interface{}{
+		"verbose":  false,
+		"pedantic": false,
+		"name":     "lansrv",
+		"lang":     "go",
+		"version":  "1",
+		"protocol": 0,
+	}
+
+	if strings.Contains(address, "://") {
+		natsURL, err := url.Parse(address)
+		if err != nil {
+			return nil, err
+		}
+		address = natsURL.Host
+		if natsURL.Port() == "" {
+			address = net.JoinHostPort(natsURL.Hostname(), "4222")
+		}
+
+		if password, ok := natsURL.User.Password(); ok {
+			options["user"], options["pass"] = natsURL.User.Username(), password
+		} else if natsURL.User != nil {
+			options["auth_token"] = natsURL.User.Username()
+		}
+	}
+
+	dialer := new(net.Dialer)
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(5 * time.Second)
+	}
+	conn.SetDeadline(deadline)
+
+	reader := bufio.NewReader(conn)
+	if line, err := reader.ReadString('\n'); err != nil {
+		conn.Close()
+		return nil, err
+	} else if !strings.HasPrefix(strings.ToUpper(line), "INFO") {
+		conn.Close()
+		return nil, fmt.Errorf("unexpected greeting from NATS server: %q", strings.TrimSpace(line))
+	}
+
+	connect, _ := json.Marshal(options)
+	if _, err := conn.Write([]byte("CONNECT " + string(connect) + "\r\nPING\r\n")); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+
+		op := strings.ToUpper(strings.TrimSpace(line))
+		if op == "PONG" {
+			break
+		}
+		if strings.HasPrefix(op, "-ERR") {
+			conn.Close()
+			return nil, errors.New("NATS server: " + strings.TrimSpace(line[4:]))
+		}
+	}
+	conn.SetDeadline(time.Time{})
+
+	natsConn := &NatsConn{
+		conn:     conn,
+		handlers: make(map[string]NatsHandler),
+		pongs:    make(chan struct{}, 1),
+		closed:   make(chan struct{}),
+	}
+	go natsConn.read(reader)
+
+	return natsConn, nil
+}
+
+// Publish sends @arg data to @arg subject.
+func (nats *NatsConn) Publish(subject string, data []byte) error {
+	return nats.write("PUB " + subject + " " + strconv.Itoa(len(data)) + "\r\n" + string(data) + "\r\n")
+}
+
+// Subscribe calls @arg handler with the messages sent to @arg subject, which may contain
+// wildcards.
+func (nats *NatsConn) Subscribe(subject string, handler NatsHandler) error {
+	nats.mutex.Lock()
+	nats.nextSid++
+	sid := strconv.Itoa(nats.nextSid)
+	nats.handlers[sid] = handler
+	nats.mutex.Unlock()
+
+	return nats.write("SUB " + subject + " " + sid + "\r\n")
+}
+
+// Flush waits until the server has processed everything sent before it.
+func (nats *NatsConn) Flush(ctx context.Context) error {
+	if err := nats.write("PING\r\n"); err != nil {
+		return err
+	}
+
+	select {
+	case <-nats.pongs:
+		return nil
+	case <-nats.closed:
+		return nats.Err()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Done is closed once the connection is lost or closed.
+func (nats *NatsConn) Done() <-chan struct{} {
+	return nats.closed
+}
+
+// Err returns why the connection was lost.
+func (nats *NatsConn) Err() error {
+	nats.mutex.Lock()
+	defer nats.mutex.Unlock()
+
+	return nats.err
+}
+
+// Close disconnects from the server.
+func (nats *NatsConn) Close() error {
+	return nats.conn.Close()
+}
+
+// natsWriteTimeout is how long a write may wait on a server that isn't reading.
+var natsWriteTimeout = 5 * time.Second
+
+// write sends @arg data, closing the connection when it fails since part of it may have been sent.
+func (nats *NatsConn) write(data string) error {
+	nats.writes.Lock()
+	defer nats.writes.Unlock()
+
+	nats.conn.SetWriteDeadline(time.Now().Add(natsWriteTimeout))
+	if _, err := io.WriteString(nats.conn, data); err != nil {
+		nats.conn.Close()
+		return err
+	}
+
+	return nil
+}
+
+// read handles the messages from the server until the connection fails.
+func (nats *NatsConn) read(reader *bufio.Reader) {
+	defer close(nats.closed)
+
+	fail := func(err error) {
+		nats.mutex.Lock()
+		if nats.err == nil {
+			nats.err = err
+		}
+		nats.mutex.Unlock()
+		nats.conn.Close()
+	}
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			fail(err)
+			return
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch strings.ToUpper(fields[0]) {
+		case "PING":
+			nats.write("PONG\r\n")
+		case "PONG":
+			select {
+			case nats.pongs <- struct{}{}:
+			default:
+			}
+		case "-ERR":
+			fail(errors.New("NATS server: " + strings.TrimSpace(line[4:])))
+		case "MSG":
+			// MSG <subject> <sid> [reply-to] <#bytes>
+			if len(fields) < 4 || len(fields) > 5 {
+				fail(fmt.Errorf("invalid message from NATS server: %q", strings.TrimSpace(line)))
+				return
+			}
+			size, err := strconv.Atoi(fields[len(fields)-1])
+			if err != nil {
+				fail(fmt.Errorf("invalid message from NATS server: %q", strings.TrimSpace(line)))
+				return
+			}
+
+			payload := make([]byte, size+2)
+			if _, err := io.ReadFull(reader, payload); err != nil {
+				fail(err)
+				return
+			}
+
+			reply := ""
+			if len(fields) == 5 {
+				reply = fields[3]
+			}
+
+			nats.mutex.Lock()
+			handler := nats.handlers[fields[2]]
+			nats.mutex.Unlock()
+			if handler != nil {
+				handler(fields[1], reply, payload[:size])
+			}
+		}
+	}
+}
+
+// NatsSink publishes the changes to the discovered services to a NATS server as JSON
+// ServiceEvents on `<prefix>.service.up.<name>` and `<prefix>.service.down.<name>` and answers
+// requests on `<prefix>.lookup.<name>` with the current instances of the service.  Dots, spaces and
+// wildcards in service names are replaced by underscores in subjects.  Lost connections are
+// re-established in the background every Retry, after which every current service is published
+// as up again, since changes made in between were missed.  Services removed in between aren't
+// published as down.
+type NatsSink struct {
+	Address string
+	Prefix  string
+	Retry   time.Duration
+
+	mutex    sync.Mutex
+	conn     *NatsConn
+	services ServiceSet
+	closed   bool
+	lost     bool
+}
+
+// ServiceInstance is an ad along with the host publishing it.
+type ServiceInstance struct {
+	Host string `json:"host"`
+	Ad   LanAd  `json:"ad"`
+}
+
+// NewNatsSink returns a sink publishing to the server at @arg address under @arg prefix, which
+// defaults to `lansrv`.  It connects on the first update.
+func NewNatsSink(address, prefix string) *NatsSink {
+	if len(prefix) == 0 {
+		prefix = "lansrv"
+	}
+
+	return &NatsSink{Address: address, Prefix: prefix, Retry: 5 * time.Second}
+}
+
+func (sink *NatsSink) Update(services ServiceSet, events []ServiceEvent) error {
+	sink.mutex.Lock()
+	sink.services = services
+	sink.mutex.Unlock()
+
+	conn, err := sink.connect()
+	if err != nil {
+		return err
+	}
+
+	for _, event := range events {
+		state := "up"
+		if event.Type == Removed {
+			state = "down"
+		}
+
+		data, _ := json.Marshal(event)
+		if err := conn.Publish(sink.Prefix+".service."+state+"."+natsToken(event.Ad.Service), data); err != nil {
+			conn.Close()
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Close disconnects from the server and stops reconnecting.
+func (sink *NatsSink) Close() error {
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+
+	sink.closed = true
+	if sink.conn != nil {
+		return sink.conn.Close()
+	}
+	return nil
+}
+
+// connect returns the current connection, connecting and subscribing to lookups when there is
+// none.
+func (sink *NatsSink) connect() (*NatsConn, error) {
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+
+	if sink.closed {
+		return nil, errors.New("NATS sink is closed")
+	}
+	if sink.conn != nil {
+		return sink.conn, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := DialNats(ctx, sink.Address)
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.Subscribe(sink.Prefix+".lookup.*", sink.lookup(conn)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if sink.lost {
+		if err := sink.publishServices(conn); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		sink.lost = false
+	}
+	sink.conn = conn
+
+	go sink.reconnect(conn)
+
+	return conn, nil
+}
+
+// reconnect waits for @arg conn to be lost and connects again.
+func (sink *NatsSink) reconnect(conn *NatsConn) {
+	<-conn.Done()
+
+	sink.mutex.Lock()
+	if sink.conn == conn {
+		sink.conn, sink.lost = nil, true
+	}
+	sink.mutex.Unlock()
+
+	for {
+		time.Sleep(sink.Retry)
+
+		if _, err := sink.connect(); err == nil || sink.isClosed() {
+			return
+		}
+	}
+}
+
+// publishServices publishes every current service as up on @arg conn.  It is called with the mutex
+// held.
+func (sink *NatsSink) publishServices(conn *NatsConn) error {
+	instances := []ServiceInstance{}
+	for host, ads := range sink.services {
+		for _, ad := range ads {
+			instances = append(instances, ServiceInstance{Host: host, Ad: ad})
+		}
+	}
+	sortInstances(instances)
+
+	for _, instance := range instances {
+		data, _ := json.Marshal(ServiceEvent{Type: Added, Host: instance.Host, Ad: instance.Ad})
+		if err := conn.Publish(sink.Prefix+".service.up."+natsToken(instance.Ad.Service), data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (sink *NatsSink) isClosed() bool {
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+
+	return sink.closed
+}
+
+// lookup answers requests for the instances of a service with a JSON list of ServiceInstances.
+func (sink *NatsSink) lookup(conn *NatsConn) NatsHandler {
+	prefix := sink.Prefix + ".lookup."
+
+	return func(subject, reply string, _ []byte) {
+		if len(reply) == 0 {
+			return
+		}
+		name := strings.TrimPrefix(subject, prefix)
+
+		sink.mutex.Lock()
+		instances := []ServiceInstance{}
+		for host, ads := range sink.services {
+			for _, ad := range ads {
+				if natsToken(ad.Service) == name {
+					instances = append(instances, ServiceInstance{Host: host, Ad: ad})
+				}
+			}
+		}
+		sink.mutex.Unlock()
+
+		sortInstances(instances)
+		data, _ := json.Marshal(instances)
+		conn.Publish(reply, data)
+	}
+}
+
+func sortInstances(instances []ServiceInstance) {
+	sort.Slice(instances, func(i, j int) bool {
+		if instances[i].Host != instances[j].Host {
+			return instances[i].Host < instances[j].Host
+		}
+		return instances[i].Ad.Port < instances[j].Ad.Port
+	})
+}
+
+// natsToken makes @arg name usable as a single token of a subject.
+func natsToken(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '.', ' ', '\t', '*', '>':
+			return '_'
+		}
+		return r
+	}, name)
+}
+
+// newNatsSink takes the server `url` and the subject `prefix`.
+func newNatsSink(options map[string]string) (Sink, error) {
+	if len(options["url"]) == 0 {
+		return nil, errors.New("url is required")
+	}
+
+	return NewNatsSink(options["url"], options["prefix"]), nil
+}
@@ -0,0 +1,205 @@
+package lansrv
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type natsMessage struct {
+	subject string
+	data    string
+}
+
+// fakeNats is a stand-in NATS server for a single client.
+type fakeNats struct {
+	listener  net.Listener
+	conn      chan net.Conn
+	connect   chan string
+	published chan natsMessage
+	subs      chan [2]string
+}
+
+func newFakeNats(t *testing.T) *fakeNats {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	server := &fakeNats{
+		listener:  listener,
+		conn:      make(chan net.Conn, 1),
+		connect:   make(chan string, 1),
+		published: make(chan natsMessage, 16),
+		subs:      make(chan [2]string, 4),
+	}
+	go server.serve()
+
+	return server
+}
+
+func (server *fakeNats) serve() {
+	for {
+		conn, err := server.listener.Accept()
+		if err != nil {
+			return
+		}
+		server.handle(conn)
+	}
+}
+
+// handle speaks to one client at a time until it disconnects.
+func (server *fakeNats) handle(conn net.Conn) {
+	defer conn.Close()
+	server.conn <- conn
+
+	io.WriteString(conn, `INFO {"server_id":"fake","max_payload":1048576}`+"\r\n")
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch fields[0] {
+		case "CONNECT":
+			server.connect <- strings.TrimSpace(strings.TrimPrefix(line, "CONNECT"))
+		case "PING":
+			io.WriteString(conn, "PONG\r\n")
+		case "SUB":
+			server.subs <- [2]string{fields[1], fields[2]}
+		case "PUB":
+			size, _ := strconv.Atoi(fields[len(fields)-1])
+			payload := make([]byte, size+2)
+			io.ReadFull(reader, payload)
+			server.published <- natsMessage{fields[1], string(payload[:size])}
+		}
+	}
+}
+
+func (server *fakeNats) receive(t *testing.T) natsMessage {
+	select {
+	case msg := <-server.published:
+		return msg
+	case <-time.After(2 * time.Second):
+		t.Fatal("nothing published")
+		return natsMessage{}
+	}
+}
+
+func TestNatsSink(t *testing.T) {
+	server := newFakeNats(t)
+	sink, err := NewSink("nats:url=nats://secret@" + server.listener.Addr().String())
+	assert.NoError(t, err)
+	defer sink.(*NatsSink).Close()
+
+	files := LanAd{Service: "files", Address: net.ParseIP("10.0.0.2"), Port: 9999, Protocol: "http"}
+	mqtt := LanAd{Service: "garage.mqtt", Address: net.ParseIP("10.0.0.3"), Port: 1883, Protocol: "mqtt"}
+	assert.NoError(t, sink.Update(ServiceSet{"10.0.0.2": {files}, "10.0.0.3": {mqtt}}, []ServiceEvent{
+		{Type: Added, Host: "10.0.0.2", Ad: files},
+		{Type: Added, Host: "10.0.0.3", Ad: mqtt},
+	}))
+
+	connect := make(map[string]interface{})
+	assert.NoError(t, json.Unmarshal([]byte(<-server.connect), &connect))
+	assert.Equal(t, "secret", connect["auth_token"])
+	assert.Equal(t, [2]string{"lansrv.lookup.*", "1"}, <-server.subs)
+
+	assert.Equal(t, natsMessage{"lansrv.service.up.files",
+		`{"type":"added","host":"10.0.0.2","ad":{"Service":"files","Port":9999,"Path":"","Protocol":"http"}}`}, server.receive(t))
+	assert.Equal(t, "lansrv.service.up.garage_mqtt", server.receive(t).subject)
+
+	assert.NoError(t, sink.Update(ServiceSet{"10.0.0.2": {files}}, []ServiceEvent{{Type: Removed, Host: "10.0.0.3", Ad: mqtt}}))
+	assert.Equal(t, "lansrv.service.down.garage_mqtt", server.receive(t).subject)
+
+	// request/reply lookup
+	conn := <-server.conn
+	io.WriteString(conn, "MSG lansrv.lookup.files 1 _INBOX.abc 0\r\n\r\n")
+	assert.Equal(t, natsMessage{"_INBOX.abc",
+		`[{"host":"10.0.0.2","ad":{"Service":"files","Port":9999,"Path":"","Protocol":"http"}}]`}, server.receive(t))
+}
+
+func TestNatsSinkReconnect(t *testing.T) {
+	server := newFakeNats(t)
+	sink := NewNatsSink(server.listener.Addr().String(), "")
+	sink.Retry = 10 * time.Millisecond
+	defer sink.Close()
+
+	files := LanAd{Service: "files", Address: net.ParseIP("10.0.0.2"), Port: 9999, Protocol: "http"}
+	assert.NoError(t, sink.Update(ServiceSet{"10.0.0.2": {files}}, []ServiceEvent{{Type: Added, Host: "10.0.0.2", Ad: files}}))
+	<-server.connect
+	assert.Equal(t, "lansrv.service.up.files", server.receive(t).subject)
+
+	(<-server.conn).Close()
+
+	// the services are published again once reconnected
+	<-server.connect
+	assert.Equal(t, [2]string{"lansrv.lookup.*", "1"}, <-server.subs)
+	assert.Equal(t, natsMessage{"lansrv.service.up.files",
+		`{"type":"added","host":"10.0.0.2","ad":{"Service":"files","Port":9999,"Path":"","Protocol":"http"}}`}, server.receive(t))
+}
+
+func TestNatsConnWriteTimeout(t *testing.T) {
+	defer func(timeout time.Duration) { natsWriteTimeout = timeout }(natsWriteTimeout)
+	natsWriteTimeout = 100 * time.Millisecond
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer listener.Close()
+
+	stalled := make(chan struct{})
+	defer close(stalled)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.WriteString(conn, "INFO {}\r\n")
+		reader := bufio.NewReader(conn)
+		reader.ReadString('\n')
+		reader.ReadString('\n')
+		io.WriteString(conn, "PONG\r\n")
+		// then stops reading
+		<-stalled
+	}()
+
+	conn, err := DialNats(context.Background(), listener.Addr().String())
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	assert.Error(t, conn.Publish("big", make([]byte, 64<<20)))
+}
+
+func TestDialNatsError(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer listener.Close()
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.WriteString(conn, "INFO {}\r\n")
+		bufio.NewReader(conn).ReadString('\n')
+		io.WriteString(conn, "-ERR 'Authorization Violation'\r\n")
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	_, err = DialNats(ctx, listener.Addr().String())
+	assert.EqualError(t, err, "NATS server: 'Authorization Violation'")
+}
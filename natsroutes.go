@@ -0,0 +1,114 @@
+package lansrv
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"syscall"
+)
+
+// NatsRoutesSink keeps a nats-server config include at Path listing a cluster route to every
+// other node advertising Service, then signals the nats-server in PidFile to reload it.  Include
+// it from nats-server.conf with `include ./routes.conf` in place of a cluster block.
+type NatsRoutesSink struct {
+	Path    string
+	Service string
+	// RoutePort overrides the advertised port, for ads that advertise the client port rather than
+	// the cluster port.
+	RoutePort int
+	// Listen and Name are written to the cluster block when set.
+	Listen, Name string
+	PidFile      string
+	Signal       syscall.Signal
+	// Self lists the addresses of this node, which are left out of the routes.  The addresses of
+	// the local interfaces are used when it is nil.
+	Self []net.IP
+}
+
+// NewNatsRoutesSink returns a sink writing the routes to the nodes advertising @arg service to
+// @arg path and sending SIGHUP to the process in @arg pidFile, if set, when they change.
+func NewNatsRoutesSink(path, service, pidFile string) *NatsRoutesSink {
+	return &NatsRoutesSink{Path: path, Service: service, PidFile: pidFile, Signal: syscall.SIGHUP}
+}
+
+func (sink *NatsRoutesSink) Update(services ServiceSet, _ []ServiceEvent) error {
+	changed, err := WriteFileAtomic(sink.Path, sink.Render(services), 0644)
+	if err != nil || !changed || len(sink.PidFile) == 0 {
+		return err
+	}
+
+	return SignalPidFile(sink.PidFile, sink.Signal)
+}
+
+// Render returns the include file for @arg services.
+func (sink *NatsRoutesSink) Render(services ServiceSet) []byte {
+	self := hostIPs()
+	if sink.Self != nil {
+		self = make(map[string]interface{})
+		for _, ip := range sink.Self {
+			self[ip.String()] = struct{}{}
+		}
+	}
+
+	unique := make(map[string]bool)
+	for _, ad := range ServiceAds(services, sink.Service) {
+		if _, local := self[ad.Address.String()]; ad.Address == nil || local {
+			continue
+		}
+
+		port := ad.Port
+		if sink.RoutePort > 0 {
+			port = sink.RoutePort
+		}
+		unique["nats-route://"+net.JoinHostPort(ad.Address.String(), strconv.Itoa(port))] = true
+	}
+	routes := sortedKeys(unique)
+
+	conf := &bytes.Buffer{}
+	fmt.Fprintf(conf, "# Generated by lansrv from the %s services on the network.\ncluster {\n", sink.Service)
+	if len(sink.Name) > 0 {
+		fmt.Fprintf(conf, "  name: %q\n", sink.Name)
+	}
+	if len(sink.Listen) > 0 {
+		fmt.Fprintf(conf, "  listen: %q\n", sink.Listen)
+	}
+	conf.WriteString("  routes = [\n")
+	for _, route := range routes {
+		fmt.Fprintf(conf, "    %q\n", route)
+	}
+	conf.WriteString("  ]\n}\n")
+
+	return conf.Bytes()
+}
+
+// newNatsRoutesSink takes the include file `path`, the `service` (nats-node by default), the
+// `pidfile` and `signal` of nats-server and the cluster `port`, `listen` and `name` settings.
+func newNatsRoutesSink(options map[string]string) (Sink, error) {
+	if len(options["path"]) == 0 {
+		return nil, errors.New("path is required")
+	}
+
+	service := options["service"]
+	if len(service) == 0 {
+		service = "nats-node"
+	}
+	sink := NewNatsRoutesSink(options["path"], service, options["pidfile"])
+	sink.Listen, sink.Name = options["listen"], options["name"]
+
+	if port, ok := options["port"]; ok {
+		var err error
+		if sink.RoutePort, err = strconv.Atoi(port); err != nil {
+			return nil, errors.New("invalid port " + port)
+		}
+	}
+	if name, ok := options["signal"]; ok {
+		var err error
+		if sink.Signal, err = ParseSignal(name); err != nil {
+			return nil, err
+		}
+	}
+
+	return sink, nil
+}
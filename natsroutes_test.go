@@ -0,0 +1,50 @@
+package lansrv
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNatsRoutesSink(t *testing.T) {
+	dir := t.TempDir()
+	pidFile := filepath.Join(dir, "nats-server.pid")
+	ioutil.WriteFile(pidFile, []byte(strconv.Itoa(os.Getpid())), 0644)
+
+	sigc := make(chan os.Signal, 4)
+	signal.Notify(sigc, syscall.SIGHUP)
+	defer signal.Stop(sigc)
+
+	sink, err := NewSink("nats-routes:path=" + filepath.Join(dir, "routes.conf") + ",pidfile=" + pidFile + ",port=6222,listen=0.0.0.0:6222")
+	assert.NoError(t, err)
+	sink.(*NatsRoutesSink).Self = []net.IP{net.ParseIP("10.0.0.1")}
+
+	assertRoutes := func(services ServiceSet, routes string, signaled bool) {
+		assert.NoError(t, sink.Update(services, nil))
+
+		conf, _ := ioutil.ReadFile(filepath.Join(dir, "routes.conf"))
+		assert.Equal(t, "# Generated by lansrv from the nats-node services on the network.\ncluster {\n"+
+			"  listen: \"0.0.0.0:6222\"\n  routes = [\n"+routes+"  ]\n}\n", string(conf))
+
+		select {
+		case <-sigc:
+			assert.True(t, signaled, "unexpected SIGHUP")
+		case <-time.After(100 * time.Millisecond):
+			assert.False(t, signaled, "no SIGHUP")
+		}
+	}
+
+	assertRoutes(natsNodes("10.0.0.1", "10.0.0.3", "10.0.0.2"),
+		"    \"nats-route://10.0.0.2:6222\"\n    \"nats-route://10.0.0.3:6222\"\n", true)
+	assertRoutes(natsNodes("10.0.0.3", "10.0.0.2"),
+		"    \"nats-route://10.0.0.2:6222\"\n    \"nats-route://10.0.0.3:6222\"\n", false)
+	assertRoutes(natsNodes("10.0.0.1"), "", true)
+}
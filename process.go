@@ -0,0 +1,166 @@
+package lansrv
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// EnvBinding exports the endpoints of Service to a child process as the environment variable Name.
+type EnvBinding struct {
+	Name, Service string
+}
+
+// ProcessSink runs a child process with the endpoints of discovered services in its environment,
+// formatted with ToFormattedString and separated by Delimiter.  When the endpoints change the
+// child is restarted with the new environment if Restart is set, or sent Signal if that is set.
+type ProcessSink struct {
+	Args              []string
+	Env               []EnvBinding
+	Format, Delimiter string
+	Restart           bool
+	Signal            syscall.Signal
+	// StopTimeout is how long a child gets to exit after SIGTERM when restarting before it is killed.
+	StopTimeout time.Duration
+
+	mutex    sync.Mutex
+	cmd      *exec.Cmd
+	waited   chan struct{}
+	stopping bool
+	killed   bool
+	values   []string
+	exited   chan error
+}
+
+// NewProcessSink returns a sink running @arg args, which must name a command.
+func NewProcessSink(args []string, env []EnvBinding, format, delimiter string) (*ProcessSink, error) {
+	if len(args) == 0 {
+		return nil, errors.New("no command to run")
+	}
+
+	return &ProcessSink{
+		Args:        args,
+		Env:         env,
+		Format:      format,
+		Delimiter:   delimiter,
+		StopTimeout: 10 * time.Second,
+		exited:      make(chan error, 1),
+	}, nil
+}
+
+// Exited receives the child's exit status when it exits other than being restarted.
+func (sink *ProcessSink) Exited() <-chan error {
+	return sink.exited
+}
+
+// ErrNotStarted is returned by Kill when the child hadn't been started yet.
+var ErrNotStarted = errors.New("command not started")
+
+// Kill sends @arg sig to the child.  A child that hasn't been started yet never will be, since
+// nothing would have received the signal, and ErrNotStarted is returned instead.
+func (sink *ProcessSink) Kill(sig os.Signal) error {
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+
+	if sink.cmd == nil {
+		sink.killed = true
+		return ErrNotStarted
+	}
+	return sink.cmd.Process.Signal(sig)
+}
+
+// Update starts the child on the first update and applies endpoint changes afterwards.
+func (sink *ProcessSink) Update(services ServiceSet, _ []ServiceEvent) error {
+	values := make([]string, len(sink.Env))
+	for i, binding := range sink.Env {
+		values[i] = strings.Join(Endpoints(services, binding.Service, sink.Format), sink.Delimiter)
+	}
+
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+
+	switch {
+	case sink.killed:
+		return nil
+	case sink.cmd == nil:
+	case equalStrings(values, sink.values):
+		return nil
+	case sink.Restart:
+		sink.stop()
+	case sink.Signal != 0:
+		sink.values = values
+		return sink.cmd.Process.Signal(sink.Signal)
+	default:
+		return nil
+	}
+
+	return sink.start(values)
+}
+
+func (sink *ProcessSink) start(values []string) error {
+	cmd := exec.Command(sink.Args[0], sink.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.Env = os.Environ()
+	for i, binding := range sink.Env {
+		cmd.Env = append(cmd.Env, binding.Name+"="+values[i])
+	}
+
+	if err := cmd.Start(); err != nil {
+		// nothing is running anymore, so this ends the sink like an exit would
+		select {
+		case sink.exited <- err:
+		default:
+		}
+		return err
+	}
+	sink.cmd, sink.values, sink.stopping = cmd, values, false
+
+	waited := make(chan struct{})
+	sink.waited = waited
+	go func() {
+		err := cmd.Wait()
+		close(waited)
+
+		sink.mutex.Lock()
+		defer sink.mutex.Unlock()
+		if sink.cmd == cmd && !sink.stopping {
+			sink.exited <- err
+		}
+	}()
+
+	return nil
+}
+
+// stop terminates the running child, killing it if it hasn't exited after StopTimeout.  It is
+// called with the mutex held, which it releases while waiting.
+func (sink *ProcessSink) stop() {
+	sink.stopping = true
+	process, waited := sink.cmd.Process, sink.waited
+	process.Signal(syscall.SIGTERM)
+
+	sink.mutex.Unlock()
+	select {
+	case <-waited:
+	case <-time.After(sink.StopTimeout):
+		process.Kill()
+		<-waited
+	}
+	sink.mutex.Lock()
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
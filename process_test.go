@@ -0,0 +1,102 @@
+package lansrv
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// waitForFile returns the content of the file at @arg path once it ends with @arg suffix.
+func waitForFile(t *testing.T, path, suffix string) string {
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		data, _ := ioutil.ReadFile(path)
+		if strings.HasSuffix(string(data), suffix) || time.Now().After(deadline) {
+			return string(data)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func natsNodes(addrs ...string) ServiceSet {
+	services := ServiceSet{}
+	for _, addr := range addrs {
+		services[addr] = []LanAd{{Service: "nats-node", Address: net.ParseIP(addr), Port: 4222, Protocol: "nats"}}
+	}
+	return services
+}
+
+func TestProcessSinkRestart(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "routes")
+	sink, err := NewProcessSink([]string{"sh", "-c", `echo "$ROUTES" >> ` + out + `; exec sleep 10`},
+		[]EnvBinding{{Name: "ROUTES", Service: "nats-node"}}, "%pro%://%addr%:%port%", ",")
+	assert.NoError(t, err)
+	sink.Restart = true
+
+	assert.NoError(t, sink.Update(natsNodes("10.0.0.2"), nil))
+	assert.Equal(t, "nats://10.0.0.2:4222\n", waitForFile(t, out, "\n"))
+
+	// unrelated changes leave the child alone
+	services := natsNodes("10.0.0.2")
+	services["10.0.0.9"] = []LanAd{{Service: "files", Address: net.ParseIP("10.0.0.9"), Port: 9999}}
+	assert.NoError(t, sink.Update(services, nil))
+
+	assert.NoError(t, sink.Update(natsNodes("10.0.0.2", "10.0.0.3"), nil))
+	assert.Equal(t, "nats://10.0.0.2:4222\nnats://10.0.0.2:4222,nats://10.0.0.3:4222\n", waitForFile(t, out, "4222,nats://10.0.0.3:4222\n"))
+
+	assert.NoError(t, sink.Kill(syscall.SIGTERM))
+	select {
+	case err := <-sink.Exited():
+		assert.Error(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("child did not exit")
+	}
+}
+
+func TestProcessSinkSignal(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+	sink, err := NewProcessSink([]string{"sh", "-c", `trap 'echo reload >> ` + out + `' HUP; echo started >> ` + out + `; while true; do sleep 0.01; done`},
+		[]EnvBinding{{Name: "ROUTES", Service: "nats-node"}}, "%addr%", ",")
+	assert.NoError(t, err)
+	sink.Signal = syscall.SIGHUP
+
+	assert.NoError(t, sink.Update(natsNodes("10.0.0.2"), nil))
+	waitForFile(t, out, "started\n")
+	assert.NoError(t, sink.Update(natsNodes("10.0.0.3"), nil))
+	assert.Equal(t, "started\nreload\n", waitForFile(t, out, "reload\n"))
+
+	sink.Kill(syscall.SIGKILL)
+	<-sink.Exited()
+}
+
+func TestProcessSinkExit(t *testing.T) {
+	sink, err := NewProcessSink([]string{"sh", "-c", "exit 3"}, nil, "%addr%", ",")
+	assert.NoError(t, err)
+
+	assert.NoError(t, sink.Update(ServiceSet{}, nil))
+	exitErr := &exec.ExitError{}
+	assert.True(t, errors.As(<-sink.Exited(), &exitErr))
+	assert.Equal(t, 3, exitErr.ExitCode())
+
+	_, err = NewProcessSink(nil, nil, "", "")
+	assert.Error(t, err)
+}
+
+func TestProcessSinkKillBeforeStart(t *testing.T) {
+	sink, err := NewProcessSink([]string{"true"}, nil, "%addr%", ",")
+	assert.NoError(t, err)
+
+	assert.Equal(t, ErrNotStarted, sink.Kill(syscall.SIGTERM))
+
+	// the command isn't started once it was signalled
+	assert.NoError(t, sink.Update(natsNodes("10.0.0.2"), nil))
+	assert.Nil(t, sink.cmd)
+}
@@ -0,0 +1,250 @@
+package lansrv
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unsafe"
+)
+
+// ProcRoot and CgroupRoot are where the proc and cgroup file systems are mounted.
+var (
+	ProcRoot   = "/proc"
+	CgroupRoot = "/sys/fs/cgroup"
+)
+
+// Listener is a socket accepting connections, or datagrams for udp, on the local host.
+type Listener struct {
+	IP        net.IP
+	Port      int
+	Transport string
+	Inode     uint64
+}
+
+const (
+	tcpListen = "0A"
+	udpClose  = "07"
+)
+
+// ListeningSockets reads the tcp and udp sockets of both address families from /proc/net that are
+// listening for connections.
+func ListeningSockets() ([]Listener, error) {
+	listeners := []Listener{}
+	tables := []struct{ file, transport, state string }{
+		{"tcp", "tcp", tcpListen},
+		{"tcp6", "tcp", tcpListen},
+		{"udp", "udp", udpClose},
+		{"udp6", "udp", udpClose},
+	}
+
+	read := 0
+	for _, table := range tables {
+		found, err := readSocketTable(filepath.Join(ProcRoot, "net", table.file), table.transport, table.state)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		read++
+		listeners = append(listeners, found...)
+	}
+
+	if read == 0 {
+		return nil, errors.New("no socket tables found in " + filepath.Join(ProcRoot, "net"))
+	}
+
+	return listeners, nil
+}
+
+func readSocketTable(path, transport, state string) ([]Listener, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	listeners := []Listener{}
+	scanner := bufio.NewScanner(f)
+	scanner.Scan() // header
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 || fields[3] != state {
+			continue
+		}
+
+		local := strings.Split(fields[1], ":")
+		if len(local) != 2 {
+			continue
+		}
+		if transport == "udp" && !strings.HasSuffix(fields[2], ":0000") {
+			// connected udp sockets aren't waiting on anyone new
+			continue
+		}
+
+		ip, err := parseProcIP(local[0])
+		if err != nil {
+			continue
+		}
+		port, err := strconv.ParseUint(local[1], 16, 16)
+		if err != nil {
+			continue
+		}
+		inode, err := strconv.ParseUint(fields[9], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		listeners = append(listeners, Listener{IP: ip, Port: int(port), Transport: transport, Inode: inode})
+	}
+
+	return listeners, scanner.Err()
+}
+
+// hostOrder is the byte order of this machine, which /proc/net writes addresses in.
+var hostOrder binary.ByteOrder = binary.LittleEndian
+
+func init() {
+	one := uint16(1)
+	if *(*byte)(unsafe.Pointer(&one)) == 0 {
+		hostOrder = binary.BigEndian
+	}
+}
+
+// parseProcIP decodes an address from /proc/net which is written as hex in 32 bit words, each the
+// value of the network ordered bytes read in host byte order.
+func parseProcIP(hexIP string) (net.IP, error) {
+	raw, err := hex.DecodeString(hexIP)
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) != net.IPv4len && len(raw) != net.IPv6len {
+		return nil, errors.New("invalid address " + hexIP)
+	}
+
+	ip := make(net.IP, len(raw))
+	for word := 0; word < len(raw); word += 4 {
+		hostOrder.PutUint32(ip[word:], binary.BigEndian.Uint32(raw[word:]))
+	}
+
+	return ip, nil
+}
+
+// UnitListeners finds the processes of systemd unit @arg unitName through its cgroup and returns
+// the sockets they are listening on.  @arg owner limits the search to that user's systemd instance
+// for user units.
+func UnitListeners(unitName, owner string) ([]Listener, error) {
+	pids, err := unitPids(unitName, owner)
+	if err != nil {
+		return nil, err
+	}
+
+	inodes := make(map[uint64]bool)
+	for _, pid := range pids {
+		fdDir := filepath.Join(ProcRoot, pid, "fd")
+		fds, err := ioutil.ReadDir(fdDir)
+		if err != nil {
+			continue
+		}
+
+		for _, fd := range fds {
+			target, err := os.Readlink(filepath.Join(fdDir, fd.Name()))
+			if err != nil || !strings.HasPrefix(target, "socket:[") {
+				continue
+			}
+
+			inode, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(target, "socket:["), "]"), 10, 64)
+			if err == nil {
+				inodes[inode] = true
+			}
+		}
+	}
+
+	sockets, err := ListeningSockets()
+	if err != nil {
+		return nil, err
+	}
+
+	listeners := []Listener{}
+	for _, socket := range sockets {
+		if inodes[socket.Inode] {
+			listeners = append(listeners, socket)
+		}
+	}
+
+	return listeners, nil
+}
+
+// unitPids returns the pids of every process in the unit's cgroup, including nested cgroups.  The
+// cgroup is looked for in the slices systemd puts units in: those of the system manager, or those
+// of @arg owner's manager for user units, in the unified hierarchy or the systemd one of cgroup v1.
+func unitPids(unitName, owner string) ([]string, error) {
+	managerPath := ""
+	if len(owner) > 0 {
+		u, err := user.Lookup(owner)
+		if err != nil {
+			return nil, err
+		}
+		managerPath = filepath.Join("user.slice", "user-"+u.Uid+".slice", "user@"+u.Uid+".service")
+	}
+
+	unitDir := ""
+	for _, hierarchy := range []string{"", "unified", "systemd"} {
+		if unitDir = findUnitCgroup(filepath.Join(CgroupRoot, hierarchy, managerPath), unitName); len(unitDir) > 0 {
+			break
+		}
+	}
+
+	if len(unitDir) == 0 {
+		return nil, errors.New("no cgroup found for " + unitName)
+	}
+
+	pids := []string{}
+	err := filepath.Walk(unitDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.Name() != "cgroup.procs" {
+			return err
+		}
+
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		pids = append(pids, strings.Fields(string(data))...)
+
+		return nil
+	})
+
+	return pids, err
+}
+
+// findUnitCgroup returns the cgroup of @arg unitName in @arg dir or the slices below it.  Only
+// slices hold units, so the cgroups of other units, including the user managers, aren't searched.
+func findUnitCgroup(dir, unitName string) string {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return ""
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() && entry.Name() == unitName {
+			return filepath.Join(dir, unitName)
+		}
+	}
+	for _, entry := range entries {
+		if entry.IsDir() && strings.HasSuffix(entry.Name(), ".slice") {
+			if found := findUnitCgroup(filepath.Join(dir, entry.Name()), unitName); len(found) > 0 {
+				return found
+			}
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,123 @@
+package lansrv
+
+import (
+	"encoding/binary"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	procTCP = `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000:1F90 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 5555 1 0000000000000000 100 0 0 10 0
+   1: 0100007F:0CEA 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 7777 1 0000000000000000 100 0 0 10 0
+   2: 0100007F:1F90 0100007F:D431 01 00000000:00000000 00:00000000 00000000     0        0 8888 1 0000000000000000 100 0 0 10 0
+`
+	procTCP6 = `  sl  local_address                         remote_address                        st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000000000000000000000000000:0050 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 9999 1 0000000000000000 100 0 0 10 0
+`
+	procUDP = `   sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode ref pointer drops
+  100: 00000000:14E9 00000000:0000 07 00000000:00000000 00:00000000 00000000     0        0 6666 2 0000000000000000 0
+`
+)
+
+// procFixture builds a /proc and /sys/fs/cgroup tree where web.service runs pid 100 holding the
+// sockets with inodes 5555 (tcp 8080) and 6666 (udp 5353).
+func procFixture(t *testing.T) {
+	root := t.TempDir()
+
+	oldProc, oldCgroup := ProcRoot, CgroupRoot
+	t.Cleanup(func() { ProcRoot, CgroupRoot = oldProc, oldCgroup })
+	ProcRoot, CgroupRoot = filepath.Join(root, "proc"), filepath.Join(root, "cgroup")
+
+	writeUnitFile(t, filepath.Join(ProcRoot, "net", "tcp"), procTCP)
+	writeUnitFile(t, filepath.Join(ProcRoot, "net", "tcp6"), procTCP6)
+	writeUnitFile(t, filepath.Join(ProcRoot, "net", "udp"), procUDP)
+	writeUnitFile(t, filepath.Join(CgroupRoot, "system.slice", "web.service", "cgroup.procs"), "100\n")
+	writeUnitFile(t, filepath.Join(CgroupRoot, "system.slice", "other.service", "cgroup.procs"), "200\n")
+
+	fds := map[string]string{"0": "/dev/null", "3": "socket:[5555]", "4": "socket:[6666]", "5": "pipe:[42]"}
+	fdDir := filepath.Join(ProcRoot, "100", "fd")
+	if err := os.MkdirAll(fdDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for fd, target := range fds {
+		if err := os.Symlink(target, filepath.Join(fdDir, fd)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestListeningSockets(t *testing.T) {
+	procFixture(t)
+
+	listeners, err := ListeningSockets()
+	assert.NoError(t, err)
+	assert.Equal(t, []Listener{
+		{IP: net.IPv4(0, 0, 0, 0).To4(), Port: 8080, Transport: "tcp", Inode: 5555},
+		{IP: net.IPv4(127, 0, 0, 1).To4(), Port: 3306, Transport: "tcp", Inode: 7777},
+		{IP: net.IPv6zero, Port: 80, Transport: "tcp", Inode: 9999},
+		{IP: net.IPv4(0, 0, 0, 0).To4(), Port: 5353, Transport: "udp", Inode: 6666},
+	}, listeners)
+}
+
+func TestParseServiceFilesAutoPort(t *testing.T) {
+	procFixture(t)
+
+	dir := t.TempDir()
+	writeUnitFile(t, filepath.Join(dir, "web.service"), "[LanSrv]\nPort=auto\n")
+	writeUnitFile(t, filepath.Join(dir, "missing.service"), "[LanSrv]\nPort=auto\n")
+
+	ads := ParseServiceFiles(GatherServiceConfigs(dir))
+	assert.Equal(t, []LanAd{
+		{Service: "web", Port: 8080, Protocol: "http", Transport: "tcp"},
+		{Service: "web", Port: 5353, Protocol: "http", Transport: "udp"},
+	}, ads)
+}
+
+func TestUnitPids(t *testing.T) {
+	procFixture(t)
+
+	current, err := user.Current()
+	if err != nil {
+		t.Skip(err)
+	}
+	userDir := filepath.Join(CgroupRoot, "user.slice", "user-"+current.Uid+".slice", "user@"+current.Uid+".service")
+	writeUnitFile(t, filepath.Join(userDir, "web.service", "cgroup.procs"), "300\n")
+	writeUnitFile(t, filepath.Join(userDir, "app.slice", "sync.service", "cgroup.procs"), "400\n")
+	writeUnitFile(t, filepath.Join(CgroupRoot, "custom.slice", "batch.service", "worker", "cgroup.procs"), "500\n")
+
+	pids, err := unitPids("web.service", "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"100"}, pids)
+
+	pids, err = unitPids("web.service", current.Username)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"300"}, pids)
+
+	// units are found in any slice, like app.slice for user units or one set with Slice=
+	pids, err = unitPids("sync.service", current.Username)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"400"}, pids)
+	pids, err = unitPids("batch.service", "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"500"}, pids)
+
+	// a user's unit isn't a system unit of the same name
+	_, err = unitPids("sync.service", "")
+	assert.Error(t, err)
+}
+
+func TestParseProcIPBigEndian(t *testing.T) {
+	old := hostOrder
+	t.Cleanup(func() { hostOrder = old })
+	hostOrder = binary.BigEndian
+
+	ip, err := parseProcIP("7F000001")
+	assert.NoError(t, err)
+	assert.Equal(t, net.IPv4(127, 0, 0, 1).To4(), ip)
+}
@@ -0,0 +1,190 @@
+package lansrv
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// TargetFilter selects the ads to scrape.  Every field that is set must match.
+type TargetFilter struct {
+	Service, Protocol, Tag string
+}
+
+// DefaultTargetFilters select the ads using the `prometheus` protocol or tagged `metrics`.
+var DefaultTargetFilters = []TargetFilter{{Protocol: "prometheus"}, {Tag: "metrics"}}
+
+// Matches reports whether @arg ad is selected by the filter.
+func (filter TargetFilter) Matches(ad LanAd) bool {
+	if len(filter.Service) > 0 && ad.Service != filter.Service {
+		return false
+	}
+	if len(filter.Protocol) > 0 && ad.Protocol != filter.Protocol {
+		return false
+	}
+	if len(filter.Tag) == 0 {
+		return true
+	}
+	for _, tag := range ad.Tags {
+		if tag == filter.Tag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// TargetGroup is an entry of Prometheus' HTTP and file based service discovery formats.
+type TargetGroup struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels"`
+}
+
+// PrometheusTargets returns a target group for each ad in @arg services matched by any of
+// @arg filters, sorted by target.  Each is labeled with its `service`, `host` and `protocol` and
+// the `__meta_lansrv_` labels `path`, `transport`, `user` and `tags`, the latter as a comma
+// delimited list with a leading and trailing comma for regex matching.  The ad's path, when set,
+// becomes the `__metrics_path__`.
+func PrometheusTargets(services ServiceSet, filters []TargetFilter) []TargetGroup {
+	groups := []TargetGroup{}
+	for host, ads := range services {
+		for _, ad := range ads {
+			matched := false
+			for _, filter := range filters {
+				matched = matched || filter.Matches(ad)
+			}
+			if !matched {
+				continue
+			}
+
+			address := host
+			if ad.Address != nil {
+				address = ad.Address.String()
+			}
+
+			labels := map[string]string{
+				"service":                 ad.Service,
+				"host":                    host,
+				"protocol":                ad.Protocol,
+				"__meta_lansrv_path":      ad.Path,
+				"__meta_lansrv_transport": ad.transport(),
+				"__meta_lansrv_user":      ad.User,
+				"__meta_lansrv_tags":      "",
+			}
+			if len(ad.Tags) > 0 {
+				labels["__meta_lansrv_tags"] = "," + strings.Join(ad.Tags, ",") + ","
+			}
+			if len(ad.Path) > 0 {
+				labels["__metrics_path__"] = "/" + strings.TrimPrefix(ad.Path, "/")
+			}
+
+			groups = append(groups, TargetGroup{
+				Targets: []string{net.JoinHostPort(address, strconv.Itoa(ad.Port))},
+				Labels:  labels,
+			})
+		}
+	}
+
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Targets[0] != groups[j].Targets[0] {
+			return groups[i].Targets[0] < groups[j].Targets[0]
+		}
+		return groups[i].Labels["service"] < groups[j].Labels["service"]
+	})
+
+	return groups
+}
+
+// PrometheusSD serves the targets selected by Filters from the latest update in Prometheus' HTTP
+// service discovery format.
+type PrometheusSD struct {
+	Filters []TargetFilter
+
+	mutex   sync.Mutex
+	targets []byte
+}
+
+func (sd *PrometheusSD) Update(services ServiceSet, _ []ServiceEvent) error {
+	data, _ := json.Marshal(PrometheusTargets(services, sd.Filters))
+
+	sd.mutex.Lock()
+	sd.targets = data
+	sd.mutex.Unlock()
+
+	return nil
+}
+
+func (sd *PrometheusSD) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	sd.mutex.Lock()
+	targets := sd.targets
+	sd.mutex.Unlock()
+
+	if targets == nil {
+		targets = []byte("[]")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(targets)
+}
+
+// FileSDSink keeps the targets selected by Filters in the file at Path in Prometheus' file based
+// service discovery format.
+type FileSDSink struct {
+	Path    string
+	Filters []TargetFilter
+}
+
+func (sink *FileSDSink) Update(services ServiceSet, _ []ServiceEvent) error {
+	data, _ := json.MarshalIndent(PrometheusTargets(services, sink.Filters), "", "  ")
+	_, err := WriteFileAtomic(sink.Path, append(data, '\n'), 0644)
+
+	return err
+}
+
+// targetFilters returns the filter given by the `service`, `protocol` and `tag` options or the
+// default filters when there are none.
+func targetFilters(options map[string]string) []TargetFilter {
+	filter := TargetFilter{Service: options["service"], Protocol: options["protocol"], Tag: options["tag"]}
+	if filter == (TargetFilter{}) {
+		return DefaultTargetFilters
+	}
+
+	return []TargetFilter{filter}
+}
+
+// newPrometheusSD takes the address to `listen` on and the target filter options.  Targets are
+// served on every path.
+func newPrometheusSD(options map[string]string) (Sink, error) {
+	if len(options["listen"]) == 0 {
+		return nil, errors.New("listen is required")
+	}
+
+	listener, err := net.Listen("tcp", options["listen"])
+	if err != nil {
+		return nil, err
+	}
+
+	sd := &PrometheusSD{Filters: targetFilters(options)}
+	go func() {
+		if err := http.Serve(listener, sd); err != nil {
+			fmt.Println("Prometheus service discovery stopped:", err)
+		}
+	}()
+
+	return sd, nil
+}
+
+// newFileSDSink takes the `path` of the file and the target filter options.
+func newFileSDSink(options map[string]string) (Sink, error) {
+	if len(options["path"]) == 0 {
+		return nil, errors.New("path is required")
+	}
+
+	return &FileSDSink{Path: options["path"], Filters: targetFilters(options)}, nil
+}
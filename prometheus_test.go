@@ -0,0 +1,78 @@
+package lansrv
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func metricsServices() ServiceSet {
+	return ServiceSet{
+		"10.0.0.3": {
+			{Service: "node", Address: net.ParseIP("10.0.0.3"), Port: 9100, Protocol: "prometheus"},
+			{Service: "files", Address: net.ParseIP("10.0.0.3"), Port: 9999, Protocol: "http"},
+		},
+		"10.0.0.2": {
+			{Service: "nats-metrics", Address: net.ParseIP("10.0.0.2"), Port: 8222, Path: "varz", Protocol: "http", Tags: []string{"metrics", "nats"}},
+		},
+	}
+}
+
+func TestPrometheusTargets(t *testing.T) {
+	assert.Equal(t, []TargetGroup{
+		{Targets: []string{"10.0.0.2:8222"}, Labels: map[string]string{
+			"service":                 "nats-metrics",
+			"host":                    "10.0.0.2",
+			"protocol":                "http",
+			"__meta_lansrv_path":      "varz",
+			"__meta_lansrv_transport": "tcp",
+			"__meta_lansrv_user":      "",
+			"__meta_lansrv_tags":      ",metrics,nats,",
+			"__metrics_path__":        "/varz",
+		}},
+		{Targets: []string{"10.0.0.3:9100"}, Labels: map[string]string{
+			"service":                 "node",
+			"host":                    "10.0.0.3",
+			"protocol":                "prometheus",
+			"__meta_lansrv_path":      "",
+			"__meta_lansrv_transport": "tcp",
+			"__meta_lansrv_user":      "",
+			"__meta_lansrv_tags":      "",
+		}},
+	}, PrometheusTargets(metricsServices(), DefaultTargetFilters))
+
+	targets := PrometheusTargets(metricsServices(), []TargetFilter{{Protocol: "http", Tag: "nats"}})
+	assert.Len(t, targets, 1)
+	assert.Equal(t, "nats-metrics", targets[0].Labels["service"])
+}
+
+func TestPrometheusSD(t *testing.T) {
+	sd := &PrometheusSD{Filters: []TargetFilter{{Service: "node"}}}
+
+	recorder := httptest.NewRecorder()
+	sd.ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, "[]", recorder.Body.String())
+
+	assert.NoError(t, sd.Update(metricsServices(), nil))
+	recorder = httptest.NewRecorder()
+	sd.ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+	assert.JSONEq(t, `[{"targets": ["10.0.0.3:9100"], "labels": {"service": "node", "host": "10.0.0.3", "protocol": "prometheus",
+		"__meta_lansrv_path": "", "__meta_lansrv_transport": "tcp", "__meta_lansrv_user": "", "__meta_lansrv_tags": ""}}]`,
+		recorder.Body.String())
+}
+
+func TestFileSDSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lansrv.json")
+	sink, err := NewSink("file-sd:path=" + path + ",tag=nats")
+	assert.NoError(t, err)
+
+	assert.NoError(t, sink.Update(metricsServices(), nil))
+	data, _ := ioutil.ReadFile(path)
+	assert.Contains(t, string(data), `"10.0.0.2:8222"`)
+	assert.NotContains(t, string(data), `"10.0.0.3:9100"`)
+}
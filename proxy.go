@@ -0,0 +1,336 @@
+package lansrv
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// balancer spreads connections over the instances of each service from the latest update round
+// robin, passing over instances that recently failed to connect.
+type balancer struct {
+	// RetryAfter is how long a failed instance is passed over.
+	RetryAfter time.Duration
+
+	mutex    sync.Mutex
+	services ServiceSet
+	next     map[string]int
+	failed   map[string]time.Time
+}
+
+// instance is an ad along with the address to connect to it.
+type instance struct {
+	Ad      LanAd
+	Address string
+}
+
+func newBalancer() *balancer {
+	return &balancer{RetryAfter: 30 * time.Second, next: make(map[string]int), failed: make(map[string]time.Time)}
+}
+
+func (b *balancer) Update(services ServiceSet, events []ServiceEvent) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.services = services
+	// an instance coming back deserves another chance
+	for _, event := range events {
+		if event.Type == Added {
+			delete(b.failed, instanceAddress(event.Host, event.Ad))
+		}
+	}
+
+	return nil
+}
+
+// known reports whether the latest update has an ad for @arg service.
+func (b *balancer) known(service string) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for _, ads := range b.services {
+		for _, ad := range ads {
+			if ad.Service == service {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// pick returns the instances of @arg service accepted by @arg usable in the order to try them:
+// starting at the next one in turn, with the instances that recently failed last.
+func (b *balancer) pick(service string, usable func(LanAd) bool) []instance {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	instances := []instance{}
+	for host, ads := range b.services {
+		for _, ad := range ads {
+			if ad.Service == service && usable(ad) {
+				instances = append(instances, instance{Ad: ad, Address: instanceAddress(host, ad)})
+			}
+		}
+	}
+	if len(instances) == 0 {
+		return instances
+	}
+	sort.Slice(instances, func(i, j int) bool { return instances[i].Address < instances[j].Address })
+
+	start := b.next[service] % len(instances)
+	b.next[service] = start + 1
+	instances = append(instances[start:], instances[:start]...)
+
+	healthy, failed := []instance{}, []instance{}
+	for _, instance := range instances {
+		if until, ok := b.failed[instance.Address]; ok && time.Now().Before(until) {
+			failed = append(failed, instance)
+		} else {
+			healthy = append(healthy, instance)
+		}
+	}
+
+	return append(healthy, failed...)
+}
+
+// dial connects to the first instance of @arg service whose transport matches @arg network that
+// accepts the connection.
+func (b *balancer) dial(ctx context.Context, dialer *net.Dialer, network, service string) (net.Conn, string, error) {
+	transport := strings.TrimRight(network, "46")
+	instances := b.pick(service, func(ad LanAd) bool { return ad.transport() == transport })
+	if len(instances) == 0 {
+		return nil, "", fmt.Errorf("%w of %s", errNoInstance, service)
+	}
+
+	var err error
+	for _, instance := range instances {
+		var conn net.Conn
+		conn, err = dialer.DialContext(ctx, network, instance.Address)
+		if err == nil {
+			return conn, instance.Address, nil
+		}
+		if ctx.Err() != nil {
+			return nil, "", err
+		}
+		b.fail(instance.Address)
+	}
+
+	return nil, "", err
+}
+
+// fail passes over the instance at @arg address for RetryAfter.
+func (b *balancer) fail(address string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.failed[address] = time.Now().Add(b.RetryAfter)
+}
+
+// errNoInstance is returned when a service has no instance to connect to.
+var errNoInstance = errors.New("no instance")
+
+// instanceAddress returns the `address:port` of @arg ad, found on @arg host.
+func instanceAddress(host string, ad LanAd) string {
+	address := host
+	if ad.Address != nil {
+		address = ad.Address.String()
+	}
+
+	return net.JoinHostPort(address, strconv.Itoa(ad.Port))
+}
+
+// isHTTP reports whether @arg ad can be sent HTTP requests.
+func isHTTP(ad LanAd) bool {
+	return ad.Protocol == "http" || ad.Protocol == "https"
+}
+
+// isDialError reports whether @arg err happened before a connection was made, so the request can
+// safely be sent elsewhere.
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// ServiceProxy is an HTTP reverse proxy to the services of its latest update, giving each service
+// a stable URL whichever host runs it.  The service is named by the first label of the request's
+// host, e.g. `files.lan`, or else by the first segment of its path, e.g. `/files/`, which is
+// stripped.  Requests go to the service's instances round robin, at the ad's path, and fail over
+// to another instance when one can't be connected to.  Only ads with the `http` or `https`
+// protocol are proxied to.
+type ServiceProxy struct {
+	*balancer
+	Transport http.RoundTripper
+}
+
+// NewServiceProxy returns a proxy with no services until its first update.
+func NewServiceProxy() *ServiceProxy {
+	return &ServiceProxy{balancer: newBalancer(), Transport: http.DefaultTransport}
+}
+
+func (proxy *ServiceProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	service, prefix := proxy.route(r)
+	if len(service) == 0 {
+		http.Error(w, "unknown service", http.StatusNotFound)
+		return
+	}
+
+	instances := proxy.pick(service, isHTTP)
+	if len(instances) == 0 {
+		http.Error(w, "no http instance of "+service, http.StatusBadGateway)
+		return
+	}
+
+	reverse := &httputil.ReverseProxy{
+		Director: func(out *http.Request) {
+			out.URL.Path = "/" + strings.TrimPrefix(strings.TrimPrefix(out.URL.Path, prefix), "/")
+			out.URL.RawPath = ""
+			out.Header.Set("X-Forwarded-Host", r.Host)
+			if len(prefix) > 0 {
+				out.Header.Set("X-Forwarded-Prefix", prefix)
+			}
+		},
+		Transport: &failoverTransport{base: proxy.Transport, balancer: proxy.balancer, instances: instances},
+	}
+	reverse.ServeHTTP(w, r)
+}
+
+// route returns the service @arg r is for and the path prefix naming it, if any.
+func (proxy *ServiceProxy) route(r *http.Request) (service, prefix string) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if label := strings.SplitN(host, ".", 2)[0]; len(label) > 0 && proxy.known(label) {
+		return label, ""
+	}
+
+	segment := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)[0]
+	if len(segment) > 0 && proxy.known(segment) {
+		return segment, "/" + segment
+	}
+
+	return "", ""
+}
+
+// failoverTransport sends a request to the first of its instances that can be connected to, at
+// the ad's path followed by the request's.  A request with a body is only sent to another instance
+// when the body can be sent again, see replayBody.
+type failoverTransport struct {
+	base      http.RoundTripper
+	balancer  *balancer
+	instances []instance
+}
+
+func (transport *failoverTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	replayable, err := replayBody(r)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, instance := range transport.instances {
+		if i > 0 {
+			if !replayable {
+				break
+			}
+			if err := rewindBody(r); err != nil {
+				return nil, err
+			}
+		}
+
+		out := r.Clone(r.Context())
+		out.URL.Scheme, out.URL.Host = instance.Ad.Protocol, instance.Address
+		out.URL.Path = strings.TrimSuffix("/"+strings.TrimPrefix(instance.Ad.Path, "/"), "/") + r.URL.Path
+		out.URL.RawPath = ""
+
+		var resp *http.Response
+		resp, err = transport.base.RoundTrip(out)
+		if err == nil || !isDialError(err) {
+			return resp, err
+		}
+		transport.balancer.fail(instance.Address)
+	}
+
+	return nil, err
+}
+
+// maxReplayBody is the size of the largest body buffered by replayBody.
+const maxReplayBody = 1 << 20
+
+// replayBody makes the body of @arg r readable again with rewindBody, buffering bodies of up to
+// maxReplayBody bytes that have no GetBody, and reports whether it could.  The body of a request
+// that failed to dial is closed by the transport, so it can't simply be sent again.
+func replayBody(r *http.Request) (bool, error) {
+	if r.Body == nil || r.Body == http.NoBody || r.GetBody != nil {
+		return true, nil
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(r.Body, maxReplayBody+1))
+	if err != nil {
+		r.Body.Close()
+		return false, err
+	}
+	if len(data) > maxReplayBody {
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(data), r.Body), r.Body}
+		return false, nil
+	}
+
+	r.Body.Close()
+	r.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(data)), nil
+	}
+	r.Body, _ = r.GetBody()
+
+	return true, nil
+}
+
+// rewindBody replaces the body of @arg r, which may have been read, with a fresh one from GetBody.
+func rewindBody(r *http.Request) error {
+	if r.GetBody == nil {
+		return nil
+	}
+
+	body, err := r.GetBody()
+	if err != nil {
+		return err
+	}
+	r.Body = body
+
+	return nil
+}
+
+// newServiceProxy takes the address to `listen` on.
+func newServiceProxy(options map[string]string) (Sink, error) {
+	if len(options["listen"]) == 0 {
+		return nil, errors.New("listen is required")
+	}
+
+	listener, err := net.Listen("tcp", options["listen"])
+	if err != nil {
+		return nil, err
+	}
+
+	proxy := NewServiceProxy()
+	go func() {
+		if err := http.Serve(listener, proxy); err != nil {
+			fmt.Println("Proxy stopped:", err)
+		}
+	}()
+
+	return proxy, nil
+}
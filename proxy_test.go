@@ -0,0 +1,125 @@
+package lansrv
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// backendAd returns an ad for the test server @arg server.
+func backendAd(t *testing.T, service, path string, server *httptest.Server) LanAd {
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	portNum, _ := strconv.Atoi(port)
+
+	return LanAd{Service: service, Address: net.ParseIP("127.0.0.1"), Port: portNum, Path: path, Protocol: "http"}
+}
+
+// deadAd returns an ad for a port nothing listens on.
+func deadAd(t *testing.T, service string) LanAd {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	listener.Close()
+
+	return LanAd{Service: service, Address: net.ParseIP("127.0.0.1"), Port: listener.Addr().(*net.TCPAddr).Port, Protocol: "http"}
+}
+
+func proxyGet(t *testing.T, proxy http.Handler, host, path string) (int, string) {
+	r := httptest.NewRequest("GET", path, nil)
+	r.Host = host
+	recorder := httptest.NewRecorder()
+	proxy.ServeHTTP(recorder, r)
+
+	body, _ := ioutil.ReadAll(recorder.Body)
+	return recorder.Code, string(body)
+}
+
+func TestServiceProxyRoutes(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.RequestURI() + " " + r.Header.Get("X-Forwarded-Prefix")))
+	}))
+	defer backend.Close()
+
+	proxy := NewServiceProxy()
+	proxy.Update(ServiceSet{
+		"10.0.0.2": {backendAd(t, "files", "share", backend)},
+		"10.0.0.3": {{Service: "nats-node", Address: net.ParseIP("127.0.0.1"), Port: 4222, Protocol: "nats"}},
+	}, nil)
+
+	code, body := proxyGet(t, proxy, "files.lan:8080", "/docs/a.txt?x=1")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "/share/docs/a.txt?x=1 ", body)
+
+	code, body = proxyGet(t, proxy, "192.168.1.5:8080", "/files/docs/a.txt")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "/share/docs/a.txt /files", body)
+
+	code, body = proxyGet(t, proxy, "192.168.1.5:8080", "/files")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "/share/ /files", body)
+
+	code, _ = proxyGet(t, proxy, "localhost", "/printer/")
+	assert.Equal(t, http.StatusNotFound, code)
+
+	code, _ = proxyGet(t, proxy, "nats-node.lan", "/")
+	assert.Equal(t, http.StatusBadGateway, code)
+}
+
+func TestServiceProxyFailsOver(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	dead := deadAd(t, "files")
+	proxy := NewServiceProxy()
+	proxy.Update(ServiceSet{"a": {dead}, "b": {backendAd(t, "files", "", backend)}}, nil)
+
+	for i := 0; i < 4; i++ {
+		code, body := proxyGet(t, proxy, "files.lan", "/")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, "ok", body)
+	}
+
+	deadAddress := instanceAddress("a", dead)
+	instances := proxy.pick("files", func(LanAd) bool { return true })
+	assert.Len(t, instances, 2)
+	assert.Equal(t, deadAddress, instances[1].Address)
+
+	proxy.Update(ServiceSet{"b": {backendAd(t, "files", "", backend)}}, []ServiceEvent{{Type: Removed, Host: "a", Ad: dead}})
+	proxy.Update(ServiceSet{"a": {dead}, "b": {backendAd(t, "files", "", backend)}}, []ServiceEvent{{Type: Added, Host: "a", Ad: dead}})
+	assert.NotContains(t, proxy.failed, deadAddress)
+
+	proxy.Update(ServiceSet{"a": {dead}}, nil)
+	code, _ := proxyGet(t, proxy, "files.lan", "/")
+	assert.Equal(t, http.StatusBadGateway, code)
+}
+
+func TestServiceProxyFailsOverWithBody(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Write(append([]byte(r.Method+" "), body...))
+	}))
+	defer backend.Close()
+
+	proxy := NewServiceProxy()
+	proxy.Update(ServiceSet{"a": {deadAd(t, "files")}, "b": {backendAd(t, "files", "", backend)}}, nil)
+	front := httptest.NewServer(proxy)
+	defer front.Close()
+
+	// the first request goes to the dead instance before failing over
+	for _, method := range []string{"POST", "PUT"} {
+		req, _ := http.NewRequest(method, front.URL+"/files/upload", strings.NewReader("payload"))
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, method+" payload", string(body))
+	}
+}
@@ -0,0 +1,70 @@
+package lansrv
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"strings"
+	"time"
+)
+
+// SigningKey is a secret shared by the nodes of a LAN.  When it is set published ads are signed
+// with HMAC-SHA256 and discovered ads without a valid signature are dropped.  A signature covers
+// the mDNS instance name of the node publishing the ad and the time it was made, so an ad can't be
+// replayed by another node or after SignatureLifetime.
+var SigningKey []byte
+
+// SignatureLifetime is how long a signature stays valid, which also bounds how far apart the clocks
+// of the nodes may be.  Servers sign their ads again every half of it.
+var SignatureLifetime = 10 * time.Minute
+
+// SignAd returns a copy of @arg ad signed under SigningKey for the mDNS @arg instance publishing
+// it, timestamped with the current time.
+func SignAd(ad LanAd, instance string) LanAd {
+	ad.SignedAt = time.Now().Unix()
+	ad.Signature = adSignature(ad, instance)
+	return ad
+}
+
+// VerifyAd checks that @arg ad was signed under SigningKey by @arg instance within
+// SignatureLifetime and clears the signature and timestamp from the ad.
+func VerifyAd(ad *LanAd, instance string) bool {
+	signature, err := base64.StdEncoding.DecodeString(ad.Signature)
+	expected, _ := base64.StdEncoding.DecodeString(adSignature(*ad, instance))
+	signedAt := time.Unix(ad.SignedAt, 0)
+	ad.Signature, ad.SignedAt = "", 0
+	if err != nil {
+		return false
+	}
+
+	age := time.Since(signedAt)
+	if age < 0 {
+		age = -age
+	}
+
+	return age <= SignatureLifetime && hmac.Equal(signature, expected)
+}
+
+// adSignature signs @arg ad, including its timestamp, along with @arg instance.  Escapes are
+// removed from the instance name since the resolver sees it escaped.
+func adSignature(ad LanAd, instance string) string {
+	ad.Signature = ""
+	data, _ := json.Marshal(ad)
+
+	mac := hmac.New(sha256.New, SigningKey)
+	mac.Write([]byte(strings.ReplaceAll(instance, `\`, "") + "\n"))
+	mac.Write(data)
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// instanceName returns the mDNS instance name the server registers under.
+func instanceName() string {
+	if len(Instance) > 0 {
+		return Instance
+	}
+
+	host, _ := os.Hostname()
+	return host
+}
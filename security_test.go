@@ -0,0 +1,56 @@
+package lansrv
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignAd(t *testing.T) {
+	defer func(key []byte, instance string) { SigningKey, Instance = key, instance }(SigningKey, Instance)
+	SigningKey, Instance = []byte("shared secret"), "nas"
+
+	records := AdRecords([]LanAd{{Service: "files", Port: 9999, Protocol: "http"}})
+
+	ad := LanAd{}
+	assert.NoError(t, json.Unmarshal([]byte(records[0]), &ad))
+	assert.NotEmpty(t, ad.Signature)
+	assert.NotZero(t, ad.SignedAt)
+
+	tampered := ad
+	tampered.Port = 22
+	assert.False(t, VerifyAd(&tampered, "nas"))
+
+	replayed := ad
+	assert.False(t, VerifyAd(&replayed, "laptop"), "an ad replayed by another instance should be rejected")
+
+	assert.True(t, VerifyAd(&ad, "nas"))
+	assert.Equal(t, LanAd{Service: "files", Port: 9999, Protocol: "http"}, ad)
+
+	SigningKey = []byte("other secret")
+	ad = SignAd(ad, "nas")
+	SigningKey = []byte("shared secret")
+	assert.False(t, VerifyAd(&ad, "nas"))
+}
+
+func TestVerifyAdExpires(t *testing.T) {
+	defer func(key []byte) { SigningKey = key }(SigningKey)
+	SigningKey = []byte("shared secret")
+
+	sign := func(at time.Time) LanAd {
+		ad := LanAd{Service: "files", Port: 9999, SignedAt: at.Unix()}
+		ad.Signature = adSignature(ad, `my\ nas`)
+		return ad
+	}
+
+	ad := sign(time.Now().Add(-time.Minute))
+	assert.True(t, VerifyAd(&ad, "my nas"))
+
+	ad = sign(time.Now().Add(-SignatureLifetime - time.Minute))
+	assert.False(t, VerifyAd(&ad, "my nas"), "an old signature should be rejected")
+
+	ad = sign(time.Now().Add(SignatureLifetime + time.Minute))
+	assert.False(t, VerifyAd(&ad, "my nas"), "a signature from the future should be rejected")
+}
@@ -0,0 +1,258 @@
+package lansrv
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// SinkFactory creates a sink from the options given in a sink spec, see NewSink.
+type SinkFactory func(options map[string]string) (Sink, error)
+
+var sinkFactories = map[string]SinkFactory{
+	"stdout":      newStdoutSink,
+	"file":        newFileSink,
+	"exec":        newExecSink,
+	"template":    newTemplateSink,
+	"nats-routes": newNatsRoutesSink,
+	"nats":        newNatsSink,
+	"mqtt":        newMqttSink,
+	"webhook":     newWebhookSink,
+	"http-sd":     newPrometheusSD,
+	"file-sd":     newFileSDSink,
+	"proxy":       newServiceProxy,
+	"forward":     newForwarder,
+}
+
+// RegisterSink makes the sinks created by @arg factory available to NewSink as @arg name.  Like
+// RegisterSource it is meant to be called from an init function.
+func RegisterSink(name string, factory SinkFactory) {
+	sinkFactories[name] = factory
+}
+
+// SinkNames returns the names of the registered sinks, sorted.
+func SinkNames() []string {
+	names := make([]string, 0, len(sinkFactories))
+	for name := range sinkFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// NewSink creates a sink from @arg spec in `<name>[:<key>=<value>,...]` format, e.g.
+// `file:path=/run/nats-routes,service=nats-node`.  A value may contain commas as long as the text
+// following them doesn't look like another `key=`.
+func NewSink(spec string) (Sink, error) {
+	name, optionList := spec, ""
+	if i := strings.Index(spec, ":"); i >= 0 {
+		name, optionList = spec[:i], spec[i+1:]
+	}
+
+	factory, ok := sinkFactories[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown sink %q, expected one of %s", name, strings.Join(SinkNames(), ", "))
+	}
+
+	options := make(map[string]string)
+	last := ""
+	for _, option := range strings.Split(optionList, ",") {
+		key, value := option, ""
+		if i := strings.Index(option, "="); i >= 0 {
+			key, value = option[:i], option[i+1:]
+		}
+
+		switch {
+		case len(option) == 0 && len(last) == 0:
+		case strings.ContainsAny(key, " /") || !strings.Contains(option, "=") && len(last) > 0:
+			options[last] += "," + option
+		default:
+			options[key] = value
+			last = key
+		}
+	}
+
+	sink, err := factory(options)
+	if err != nil {
+		return nil, fmt.Errorf("%s sink: %v", name, err)
+	}
+
+	return sink, nil
+}
+
+// Render formats @arg services the way `lansrv -scan` prints them: as JSON, or when @arg service
+// is set, as the endpoints of that service in @arg format (see ToFormattedString) separated by
+// @arg delimiter.
+func Render(services ServiceSet, service, format, delimiter string) []byte {
+	if len(service) == 0 {
+		data, _ := json.Marshal(services)
+		return append(data, '\n')
+	}
+
+	return []byte(strings.Join(Endpoints(services, service, format), delimiter))
+}
+
+// Endpoints returns the distinct endpoints of @arg service in @arg services formatted with
+// ToFormattedString, sorted.
+func Endpoints(services ServiceSet, service, format string) []string {
+	unique := make(map[string]bool)
+	for _, ads := range services {
+		for _, ad := range ads {
+			if ad.Service == service {
+				unique[ad.ToFormattedString(format)] = true
+			}
+		}
+	}
+
+	endpoints := make([]string, 0, len(unique))
+	for endpoint := range unique {
+		endpoints = append(endpoints, endpoint)
+	}
+	sort.Strings(endpoints)
+
+	return endpoints
+}
+
+// WriteFileAtomic replaces the file at @arg path with @arg data by renaming a temporary file over
+// it, so readers never see a partial file.  The file is left alone when it already holds @arg data
+// and changed reports whether it was written.
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) (changed bool, err error) {
+	if current, err := ioutil.ReadFile(path); err == nil && bytes.Equal(current, data) {
+		return false, nil
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return false, err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return false, err
+	}
+	if err := tmp.Close(); err != nil {
+		return false, err
+	}
+
+	return true, os.Rename(tmp.Name(), path)
+}
+
+// WriterSink prints each update to Writer, either rendered like Render or, with Events set, as one
+// JSON object per change.
+type WriterSink struct {
+	Writer                     io.Writer
+	Service, Format, Delimiter string
+	Events                     bool
+}
+
+func (sink *WriterSink) Update(services ServiceSet, events []ServiceEvent) error {
+	if !sink.Events {
+		data := Render(services, sink.Service, sink.Format, sink.Delimiter)
+		if len(sink.Service) > 0 {
+			data = append(data, '\n')
+		}
+		_, err := sink.Writer.Write(data)
+		return err
+	}
+
+	encoder := json.NewEncoder(sink.Writer)
+	for _, event := range events {
+		if len(sink.Service) > 0 && event.Ad.Service != sink.Service {
+			continue
+		}
+		if err := encoder.Encode(event); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// FileSink keeps the file at Path rendered like Render, rewriting it only when its content changes.
+type FileSink struct {
+	Path                       string
+	Service, Format, Delimiter string
+}
+
+func (sink *FileSink) Update(services ServiceSet, _ []ServiceEvent) error {
+	_, err := WriteFileAtomic(sink.Path, Render(services, sink.Service, sink.Format, sink.Delimiter), 0644)
+	return err
+}
+
+// ExecSink runs Command with `sh -c` after every change, passing a JSON object with the current
+// `services` and the `events` that triggered it on stdin.
+type ExecSink struct {
+	Command string
+}
+
+func (sink *ExecSink) Update(services ServiceSet, events []ServiceEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	input, _ := json.Marshal(map[string]interface{}{"services": services, "events": events})
+
+	cmd := exec.Command("sh", "-c", sink.Command)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s: %v", sink.Command, err)
+	}
+
+	return nil
+}
+
+// newStdoutSink takes the `service`, `format` and `delim` options of `lansrv -scan` and `events=true`.
+func newStdoutSink(options map[string]string) (Sink, error) {
+	sink := &WriterSink{Writer: os.Stdout, Events: options["events"] == "true"}
+	sink.Service, sink.Format, sink.Delimiter = renderOptions(options)
+
+	return sink, nil
+}
+
+// newFileSink takes a `path` and the `service`, `format` and `delim` options of `lansrv -scan`.
+func newFileSink(options map[string]string) (Sink, error) {
+	if len(options["path"]) == 0 {
+		return nil, errors.New("path is required")
+	}
+
+	sink := &FileSink{Path: options["path"]}
+	sink.Service, sink.Format, sink.Delimiter = renderOptions(options)
+
+	return sink, nil
+}
+
+// newExecSink takes the `cmd` to run.
+func newExecSink(options map[string]string) (Sink, error) {
+	if len(options["cmd"]) == 0 {
+		return nil, errors.New("cmd is required")
+	}
+
+	return &ExecSink{Command: options["cmd"]}, nil
+}
+
+func renderOptions(options map[string]string) (service, format, delimiter string) {
+	format, delimiter = options["format"], options["delim"]
+	if len(format) == 0 {
+		format = Protocol + "://" + Address + ":" + Port + Path
+	}
+	if _, ok := options["delim"]; !ok {
+		delimiter = ","
+	}
+
+	return options["service"], format, delimiter
+}
@@ -0,0 +1,89 @@
+package lansrv
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewSink(t *testing.T) {
+	sink, err := NewSink("file:path=/run/routes,service=nats-node,format=nats://%addr%:%port%,delim=,")
+	assert.NoError(t, err)
+	assert.Equal(t, &FileSink{Path: "/run/routes", Service: "nats-node", Format: "nats://%addr%:%port%", Delimiter: ","}, sink)
+
+	sink, err = NewSink("exec:cmd=jq -c .events,.services >> /tmp/log")
+	assert.NoError(t, err)
+	assert.Equal(t, &ExecSink{Command: "jq -c .events,.services >> /tmp/log"}, sink)
+
+	_, err = NewSink("file")
+	assert.EqualError(t, err, "file sink: path is required")
+	_, err = NewSink("carrier-pigeon")
+	assert.Error(t, err)
+}
+
+func TestWriterSink(t *testing.T) {
+	services := ServiceSet{
+		"10.0.0.3": {{Service: "nats-node", Address: net.ParseIP("10.0.0.3"), Port: 4222, Protocol: "nats"}},
+		"10.0.0.2": {
+			{Service: "nats-node", Address: net.ParseIP("10.0.0.2"), Port: 4222, Protocol: "nats"},
+			{Service: "files", Address: net.ParseIP("10.0.0.2"), Port: 9999, Protocol: "http"},
+		},
+	}
+
+	out := &bytes.Buffer{}
+	sink := &WriterSink{Writer: out, Service: "nats-node", Format: "%pro%://%addr%:%port%", Delimiter: ","}
+	assert.NoError(t, sink.Update(services, nil))
+	assert.Equal(t, "nats://10.0.0.2:4222,nats://10.0.0.3:4222\n", out.String())
+
+	out.Reset()
+	sink.Events = true
+	assert.NoError(t, sink.Update(services, []ServiceEvent{
+		{Type: Added, Host: "10.0.0.2", Ad: services["10.0.0.2"][1]},
+		{Type: Removed, Host: "10.0.0.3", Ad: services["10.0.0.3"][0]},
+	}))
+	assert.Equal(t, `{"type":"removed","host":"10.0.0.3","ad":{"Service":"nats-node","Port":4222,"Path":"","Protocol":"nats"}}`+"\n", out.String())
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "routes")
+
+	changed, err := WriteFileAtomic(path, []byte("a"), 0640)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+
+	past := time.Now().Add(-time.Hour)
+	assert.NoError(t, os.Chtimes(path, past, past))
+	changed, err = WriteFileAtomic(path, []byte("a"), 0640)
+	assert.NoError(t, err)
+	assert.False(t, changed)
+
+	info, _ := os.Stat(path)
+	assert.Equal(t, os.FileMode(0640), info.Mode())
+	assert.True(t, info.ModTime().Equal(past))
+
+	files, _ := ioutil.ReadDir(filepath.Dir(path))
+	assert.Len(t, files, 1)
+}
+
+func TestExecSink(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "events")
+	sink := &ExecSink{Command: "cat > " + out}
+
+	assert.NoError(t, sink.Update(ServiceSet{}, nil))
+	_, err := os.Stat(out)
+	assert.True(t, os.IsNotExist(err))
+
+	files := LanAd{Service: "files", Port: 9999, Protocol: "http"}
+	assert.NoError(t, sink.Update(ServiceSet{"10.0.0.2": {files}}, []ServiceEvent{{Type: Added, Host: "10.0.0.2", Ad: files}}))
+	data, _ := ioutil.ReadFile(out)
+	assert.JSONEq(t, `{
+		"services": {"10.0.0.2": [{"Service":"files","Port":9999,"Path":"","Protocol":"http"}]},
+		"events": [{"type":"added","host":"10.0.0.2","ad":{"Service":"files","Port":9999,"Path":"","Protocol":"http"}}]
+	}`, string(data))
+}
@@ -0,0 +1,485 @@
+package lansrv
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/user"
+	"strings"
+	"time"
+)
+
+// AdSource provides ads for the server to publish.
+type AdSource interface {
+	// Load returns the source's current ads.  A source may return the ads it could read along with
+	// an error describing the ones it couldn't.
+	Load(ctx context.Context) ([]LanAd, error)
+	// Watch returns a channel receiving the source's full set of ads whenever it changes, closed
+	// once @arg ctx is done.  Sources that never change return nil.
+	Watch(ctx context.Context) <-chan []LanAd
+}
+
+var registeredSources []AdSource
+
+// RegisterSource adds @arg source to those the lansrv server publishes besides its built in ones.
+// It is meant to be called from an init function, so a build of lansrv only needs to import the
+// package providing the source.
+func RegisterSource(source AdSource) {
+	registeredSources = append(registeredSources, source)
+}
+
+// RegisteredSources returns the sources added with RegisterSource.
+func RegisteredSources() []AdSource {
+	return registeredSources
+}
+
+// MergedSource combines the ads of several sources, dropping duplicates with EqualTo.  Earlier
+// sources win when two sources list equal ads.
+type MergedSource struct {
+	Sources []AdSource
+
+	reloads chan reload
+}
+
+// Load loads every source, returning the merged ads and the errors of all sources that failed.
+func (merged *MergedSource) Load(ctx context.Context) ([]LanAd, error) {
+	sets, _, err := merged.load(ctx)
+	recordLoad(false)
+	return MergeAds(sets...), err
+}
+
+// Reload loads every source again, like Load, and while Watch is running hands the ads to it as
+// if the sources had changed, so they're sent when the merged ads differ from those sent last.
+// Sources that fail to load keep the ads they had.
+func (merged *MergedSource) Reload(ctx context.Context) error {
+	sets, failed, err := merged.load(ctx)
+	recordLoad(true)
+	if merged.reloads != nil {
+		select {
+		case merged.reloads <- reload{sets, failed}:
+		case <-ctx.Done():
+		}
+	}
+
+	return err
+}
+
+// reload is the result of a Reload, with the sources that failed to load.
+type reload struct {
+	sets   [][]LanAd
+	failed []bool
+}
+
+// load returns the ads of each source, which of them failed and their errors.
+func (merged *MergedSource) load(ctx context.Context) ([][]LanAd, []bool, error) {
+	sets := make([][]LanAd, len(merged.Sources))
+	failed := make([]bool, len(merged.Sources))
+	problems := []string{}
+	for i, source := range merged.Sources {
+		ads, err := source.Load(ctx)
+		if err != nil {
+			problems = append(problems, err.Error())
+			failed[i] = true
+		}
+		sets[i] = ads
+	}
+
+	if len(problems) > 0 {
+		return sets, failed, errors.New(strings.Join(problems, "\n"))
+	}
+
+	return sets, failed, nil
+}
+
+// Watch loads every source and then follows their changes, sending the merged ads whenever they
+// differ from those sent last.  The first set sent is the initial load.  Ads that haven't been
+// received yet are replaced by newer ones.
+func (merged *MergedSource) Watch(ctx context.Context) <-chan []LanAd {
+	merged.reloads = make(chan reload)
+
+	type update struct {
+		source int
+		ads    []LanAd
+	}
+	updates := make(chan update)
+
+	sets := make([][]LanAd, len(merged.Sources))
+	for i, source := range merged.Sources {
+		var changes <-chan []LanAd
+		polled, isPolled := source.(polledSource)
+		if !isPolled {
+			// watch before loading so a change in between is sent rather than lost
+			changes = source.Watch(ctx)
+		}
+
+		ads, err := source.Load(ctx)
+		if err != nil {
+			fmt.Println("Could not load all ads:", err)
+		}
+		sets[i] = ads
+
+		if isPolled {
+			// polling from this load finds any change made since
+			changes = polled.watchFrom(ctx, ads, err)
+		}
+
+		if changes == nil {
+			continue
+		}
+		go func(i int, changes <-chan []LanAd) {
+			for ads := range changes {
+				select {
+				case updates <- update{i, ads}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(i, changes)
+	}
+
+	recordLoad(false)
+
+	results := make(chan []LanAd, 1)
+	results <- MergeAds(sets...)
+	go func() {
+		defer close(results)
+
+		last := MergeAds(sets...)
+		for {
+			select {
+			case u := <-updates:
+				sets[u.source] = u.ads
+				recordLoad(true)
+			case reloaded := <-merged.reloads:
+				for i, ads := range reloaded.sets {
+					if !reloaded.failed[i] {
+						sets[i] = ads
+					}
+				}
+			case <-ctx.Done():
+				return
+			}
+
+			if ads := MergeAds(sets...); !SameAds(ads, last) {
+				last = ads
+				// never block, so a Reload can't wait on a reader that is calling it
+				select {
+				case results <- ads:
+				default:
+					select {
+					case <-results:
+					default:
+					}
+					results <- ads
+				}
+			}
+		}
+	}()
+
+	return results
+}
+
+// MergeAds concatenates @arg sets, skipping ads equal to one already listed.
+func MergeAds(sets ...[]LanAd) []LanAd {
+	merged := []LanAd{}
+	for _, ads := range sets {
+	ads_loop:
+		for _, ad := range ads {
+			for _, listed := range merged {
+				if ad.EqualTo(&listed) {
+					continue ads_loop
+				}
+			}
+
+			merged = append(merged, ad)
+		}
+	}
+
+	return merged
+}
+
+// SameAds reports whether @arg a and @arg b list exactly the same ads in the same order.
+func SameAds(a, b []LanAd) bool {
+	aData, _ := json.Marshal(a)
+	bData, _ := json.Marshal(b)
+
+	return string(aData) == string(bData)
+}
+
+// StaticSource is a fixed list of ads.
+type StaticSource []LanAd
+
+// NewFlagSource parses @arg services given in `<protocol>://<service-name>:<port>` format.
+func NewFlagSource(services []string) StaticSource {
+	ads := make(StaticSource, len(services))
+	for i, svc := range services {
+		ads[i].FromString(svc)
+	}
+
+	return ads
+}
+
+func (static StaticSource) Load(context.Context) ([]LanAd, error) {
+	return static, nil
+}
+
+func (static StaticSource) Watch(context.Context) <-chan []LanAd {
+	return nil
+}
+
+// SystemdSource reads ads from systemd unit files, see ParseServiceFiles.  It is re-read every
+// Refresh so changed files and Port=auto services are picked up.
+type SystemdSource struct {
+	// Dirs to gather units from, the user unit directories of User when empty and User is set.
+	Dirs []string
+	// User owning the units in Dirs, if any.
+	User *user.User
+	// AllUsers adds the user units of everyone logged in.
+	AllUsers bool
+	Refresh  time.Duration
+}
+
+func (systemd *SystemdSource) Load(context.Context) ([]LanAd, error) {
+	units := []UnitFile{}
+
+	switch {
+	case systemd.User != nil && len(systemd.Dirs) == 0:
+		units = append(units, GatherUserServiceConfigs(systemd.User)...)
+	case systemd.User != nil:
+		for _, unit := range GatherServiceConfigs(systemd.Dirs...) {
+			unit.User = systemd.User.Username
+			units = append(units, unit)
+		}
+	case len(systemd.Dirs) > 0:
+		units = append(units, GatherServiceConfigs(systemd.Dirs...)...)
+	}
+
+	if systemd.AllUsers {
+		for _, loggedIn := range LoggedInUsers() {
+			if systemd.User != nil && loggedIn.Uid == systemd.User.Uid {
+				continue
+			}
+			units = append(units, GatherUserServiceConfigs(loggedIn)...)
+		}
+	}
+
+	return ParseServiceFiles(units), nil
+}
+
+func (systemd *SystemdSource) Watch(ctx context.Context) <-chan []LanAd {
+	return PollSource(ctx, systemd, systemd.Refresh)
+}
+
+func (systemd *SystemdSource) watchFrom(ctx context.Context, ads []LanAd, err error) <-chan []LanAd {
+	return pollFrom(ctx, systemd, systemd.Refresh, ads, err)
+}
+
+// AdDirSource reads ads from the YAML files in a directory, see ParseAdFile.
+type AdDirSource struct {
+	Dir     string
+	Refresh time.Duration
+}
+
+func (dir *AdDirSource) Load(context.Context) ([]LanAd, error) {
+	return loadFiles(GatherAdFiles(dir.Dir), ParseAdFile)
+}
+
+func (dir *AdDirSource) Watch(ctx context.Context) <-chan []LanAd {
+	return PollSource(ctx, dir, dir.Refresh)
+}
+
+func (dir *AdDirSource) watchFrom(ctx context.Context, ads []LanAd, err error) <-chan []LanAd {
+	return pollFrom(ctx, dir, dir.Refresh, ads, err)
+}
+
+// AvahiSource reads ads from the Avahi service files in a directory, see ParseAvahiService.
+type AvahiSource struct {
+	Dir     string
+	Refresh time.Duration
+}
+
+func (avahi *AvahiSource) Load(context.Context) ([]LanAd, error) {
+	return loadFiles(GatherAvahiServices(avahi.Dir), ParseAvahiService)
+}
+
+func (avahi *AvahiSource) Watch(ctx context.Context) <-chan []LanAd {
+	return PollSource(ctx, avahi, avahi.Refresh)
+}
+
+func (avahi *AvahiSource) watchFrom(ctx context.Context, ads []LanAd, err error) <-chan []LanAd {
+	return pollFrom(ctx, avahi, avahi.Refresh, ads, err)
+}
+
+func loadFiles(files []string, parse func(string) ([]LanAd, error)) ([]LanAd, error) {
+	ads := []LanAd{}
+	problems := []string{}
+	for _, file := range files {
+		fileAds, err := parse(file)
+		if err != nil {
+			problems = append(problems, err.Error())
+		}
+		ads = MergeAds(ads, fileAds)
+	}
+
+	if len(problems) > 0 {
+		return ads, errors.New(strings.Join(problems, "\n"))
+	}
+
+	return ads, nil
+}
+
+// DockerSource advertises labeled containers, see DockerClient.ContainerAds, following the API's
+// event stream as containers start and stop.  When the API can't be reached, for instance because
+// Docker isn't running yet, it keeps retrying and sends the containers once it connects.
+type DockerSource struct {
+	Client *DockerClient
+	// Retry is the first wait before reconnecting, doubling up to a minute.  1s when zero.
+	Retry time.Duration
+}
+
+func (docker *DockerSource) Load(ctx context.Context) ([]LanAd, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	return docker.Client.ContainerAds(ctx)
+}
+
+func (docker *DockerSource) Watch(ctx context.Context) <-chan []LanAd {
+	results := make(chan []LanAd)
+
+	go func() {
+		defer close(results)
+
+		retry := docker.Retry
+		if retry <= 0 {
+			retry = time.Second
+		}
+		wait, reload, reported := retry, false, false
+
+		for ctx.Err() == nil {
+			events, err := docker.Client.Events(ctx)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				if !reported {
+					fmt.Println("Could not watch container events:", err)
+					reported = true
+				}
+				reload = true
+
+				select {
+				case <-time.After(wait):
+				case <-ctx.Done():
+					return
+				}
+				if wait < time.Minute {
+					wait *= 2
+				}
+				continue
+			}
+
+			// containers may have changed while the stream was down
+			pending := reload
+			wait, reload, reported = retry, true, false
+			for {
+				if !pending {
+					if _, open := <-events; !open {
+						break
+					}
+				}
+				pending = false
+
+				ads, err := docker.Load(ctx)
+				if err != nil {
+					fmt.Println("Could not list containers:", err)
+					continue
+				}
+
+				select {
+				case results <- ads:
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			// the stream ended, reconnect after a moment
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+			}
+		}
+	}()
+
+	return results
+}
+
+// PollSource loads @arg source every @arg interval, sending its ads whenever they change.  Load
+// errors are logged when they first occur.  It returns nil, meaning no changes, when @arg interval
+// isn't positive.
+func PollSource(ctx context.Context, source AdSource, interval time.Duration) <-chan []LanAd {
+	if interval <= 0 {
+		return nil
+	}
+
+	ads, err := source.Load(ctx)
+	return pollFrom(ctx, source, interval, ads, err)
+}
+
+// polledSource is implemented by the sources that poll for changes, so MergedSource can start
+// polling from the ads it loaded rather than have them loaded twice.
+type polledSource interface {
+	// watchFrom is Watch starting from @arg ads and @arg err, as returned by Load.
+	watchFrom(ctx context.Context, ads []LanAd, err error) <-chan []LanAd
+}
+
+// pollFrom is PollSource starting from @arg last and @arg err, as returned by Load.
+func pollFrom(ctx context.Context, source AdSource, interval time.Duration, last []LanAd, err error) <-chan []LanAd {
+	if interval <= 0 {
+		return nil
+	}
+
+	reported := ""
+	if err != nil {
+		reported = err.Error()
+	}
+
+	results := make(chan []LanAd)
+	go func() {
+		defer close(results)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+
+			ads, err := source.Load(ctx)
+			if err != nil && err.Error() != reported {
+				fmt.Println("Could not load all ads:", err)
+			}
+			reported = ""
+			if err != nil {
+				reported = err.Error()
+			}
+
+			if SameAds(ads, last) {
+				continue
+			}
+			last = ads
+
+			select {
+			case results <- ads:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return results
+}
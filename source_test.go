@@ -0,0 +1,171 @@
+package lansrv
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// chanSource is a source whose changes are sent by the test.
+type chanSource struct {
+	ads     []LanAd
+	changes chan []LanAd
+}
+
+func (source *chanSource) Load(context.Context) ([]LanAd, error) {
+	return source.ads, nil
+}
+
+func (source *chanSource) Watch(context.Context) <-chan []LanAd {
+	return source.changes
+}
+
+// failingSource fails to load once fail is set, like Docker when its daemon went away.
+type failingSource struct {
+	ads  []LanAd
+	fail bool
+}
+
+func (source *failingSource) Load(context.Context) ([]LanAd, error) {
+	if source.fail {
+		return nil, errors.New("unreachable")
+	}
+	return source.ads, nil
+}
+
+func (source *failingSource) Watch(context.Context) <-chan []LanAd {
+	return nil
+}
+
+func receiveAds(t *testing.T, ads <-chan []LanAd) []LanAd {
+	select {
+	case received := <-ads:
+		return received
+	case <-time.After(2 * time.Second):
+		t.Fatal("no ads received")
+		return nil
+	}
+}
+
+func TestMergeAds(t *testing.T) {
+	merged := MergeAds(
+		[]LanAd{{Service: "files", Port: 9999, Protocol: "http"}},
+		[]LanAd{{Service: "files", Port: 9999, Protocol: "http", Transport: "tcp"}, {Service: "dns", Port: 53, Transport: "udp"}},
+	)
+
+	assert.Equal(t, []LanAd{
+		{Service: "files", Port: 9999, Protocol: "http"},
+		{Service: "dns", Port: 53, Transport: "udp"},
+	}, merged)
+}
+
+func TestMergedSourceWatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dynamic := &chanSource{
+		ads:     []LanAd{{Service: "web", Port: 80}},
+		changes: make(chan []LanAd),
+	}
+	merged := &MergedSource{Sources: []AdSource{
+		NewFlagSource([]string{"http://files:9999"}),
+		dynamic,
+	}}
+
+	updates := merged.Watch(ctx)
+	assert.Equal(t, []LanAd{{Service: "files", Port: 9999, Protocol: "http"}, {Service: "web", Port: 80}}, receiveAds(t, updates))
+
+	dynamic.changes <- []LanAd{{Service: "web", Port: 80}, {Service: "files", Port: 9999, Protocol: "http"}}
+	dynamic.changes <- []LanAd{{Service: "web", Port: 8080}}
+	assert.Equal(t, []LanAd{{Service: "files", Port: 9999, Protocol: "http"}, {Service: "web", Port: 8080}}, receiveAds(t, updates),
+		"a change leaving the merged ads as they were shouldn't be sent")
+}
+
+func TestPollSource(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dir := t.TempDir()
+	source := &AdDirSource{Dir: dir, Refresh: 10 * time.Millisecond}
+	updates := source.Watch(ctx)
+
+	writeUnitFile(t, filepath.Join(dir, "files.yaml"), "service: files\nport: 9999\n")
+	assert.Equal(t, []LanAd{{Service: "files", Port: 9999, Protocol: "http"}}, receiveAds(t, updates))
+
+	cancel()
+	for range updates {
+	}
+}
+
+// countingSource is a polled source counting its loads.
+type countingSource struct {
+	mutex sync.Mutex
+	loads int
+}
+
+func (source *countingSource) Load(context.Context) ([]LanAd, error) {
+	source.mutex.Lock()
+	defer source.mutex.Unlock()
+
+	source.loads++
+	return []LanAd{{Service: "web", Port: 80 + source.loads}}, nil
+}
+
+func (source *countingSource) Watch(ctx context.Context) <-chan []LanAd {
+	return PollSource(ctx, source, 10*time.Millisecond)
+}
+
+func (source *countingSource) watchFrom(ctx context.Context, ads []LanAd, err error) <-chan []LanAd {
+	return pollFrom(ctx, source, 10*time.Millisecond, ads, err)
+}
+
+func TestMergedSourceSeedsPolling(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	source := &countingSource{}
+	updates := (&MergedSource{Sources: []AdSource{source}}).Watch(ctx)
+
+	assert.Equal(t, []LanAd{{Service: "web", Port: 81}}, receiveAds(t, updates), "polled sources should be loaded once at start")
+	assert.Equal(t, []LanAd{{Service: "web", Port: 82}}, receiveAds(t, updates))
+}
+
+func TestMergedSourceReload(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dynamic := &chanSource{ads: []LanAd{{Service: "web", Port: 80}}, changes: make(chan []LanAd)}
+	merged := &MergedSource{Sources: []AdSource{dynamic}}
+	updates := merged.Watch(ctx)
+	receiveAds(t, updates)
+
+	dynamic.ads = []LanAd{{Service: "web", Port: 8080}}
+	assert.NoError(t, merged.Reload(ctx))
+	assert.Equal(t, []LanAd{{Service: "web", Port: 8080}}, receiveAds(t, updates))
+
+	// the reloaded ads are what later changes are compared to
+	dynamic.changes <- []LanAd{{Service: "web", Port: 8080}}
+	dynamic.changes <- []LanAd{{Service: "web", Port: 80}}
+	assert.Equal(t, []LanAd{{Service: "web", Port: 80}}, receiveAds(t, updates))
+}
+
+func TestMergedSourceReloadKeepsFailedSources(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dynamic := &chanSource{ads: []LanAd{{Service: "web", Port: 80}}, changes: make(chan []LanAd)}
+	failing := &failingSource{ads: []LanAd{{Service: "db", Port: 5432}}}
+	merged := &MergedSource{Sources: []AdSource{dynamic, failing}}
+	updates := merged.Watch(ctx)
+	receiveAds(t, updates)
+
+	dynamic.ads = []LanAd{{Service: "web", Port: 8080}}
+	failing.fail = true
+	assert.Error(t, merged.Reload(ctx))
+	assert.Equal(t, []LanAd{{Service: "web", Port: 8080}, {Service: "db", Port: 5432}}, receiveAds(t, updates))
+}
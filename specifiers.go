@@ -0,0 +1,125 @@
+package lansrv
+
+import (
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ExpandSpecifiers replaces the systemd specifiers lansrv knows about in @arg value with the values
+// for this unit.  Supported specifiers are %n, %N, %p, %P, %i, %I, %j, %J, %H, %l, %m, %u, %U, %h
+// and %%; any others are left untouched.
+func (unit *Unit) ExpandSpecifiers(value string) string {
+	if !strings.Contains(value, "%") {
+		return value
+	}
+
+	prefix, instance := splitUnitName(unit.Name)
+	final := prefix
+	if dash := strings.LastIndex(prefix, "-"); dash > -1 {
+		final = prefix[dash+1:]
+	}
+
+	builder := &strings.Builder{}
+	for i := 0; i < len(value); i++ {
+		if value[i] != '%' || i+1 == len(value) {
+			builder.WriteByte(value[i])
+			continue
+		}
+
+		i++
+		switch value[i] {
+		case 'n':
+			builder.WriteString(unit.Name)
+		case 'N':
+			builder.WriteString(strings.TrimSuffix(unit.Name, filepath.Ext(unit.Name)))
+		case 'p':
+			builder.WriteString(prefix)
+		case 'P':
+			builder.WriteString(unescapeUnitName(prefix))
+		case 'i':
+			builder.WriteString(instance)
+		case 'I':
+			builder.WriteString(unescapeUnitName(instance))
+		case 'j':
+			builder.WriteString(final)
+		case 'J':
+			builder.WriteString(unescapeUnitName(final))
+		case 'H':
+			host, _ := os.Hostname()
+			builder.WriteString(host)
+		case 'l':
+			host, _ := os.Hostname()
+			builder.WriteString(strings.SplitN(host, ".", 2)[0])
+		case 'm':
+			id, _ := ioutil.ReadFile("/etc/machine-id")
+			builder.WriteString(strings.TrimSpace(string(id)))
+		case 'u':
+			if owner := unit.owner(); owner != nil {
+				builder.WriteString(owner.Username)
+			}
+		case 'U':
+			if owner := unit.owner(); owner != nil {
+				builder.WriteString(owner.Uid)
+			}
+		case 'h':
+			if owner := unit.owner(); owner != nil {
+				builder.WriteString(owner.HomeDir)
+			}
+		case '%':
+			builder.WriteByte('%')
+		default:
+			builder.WriteByte('%')
+			builder.WriteByte(value[i])
+		}
+	}
+
+	return builder.String()
+}
+
+// unescapeUnitName reverses systemd-escape, turning `-` back into `/` and `\xNN` into the byte it
+// encodes.
+func unescapeUnitName(escaped string) string {
+	builder := &strings.Builder{}
+	for i := 0; i < len(escaped); i++ {
+		switch {
+		case escaped[i] == '-':
+			builder.WriteByte('/')
+		case strings.HasPrefix(escaped[i:], `\x`) && i+4 <= len(escaped):
+			if b, err := strconv.ParseUint(escaped[i+2:i+4], 16, 8); err == nil {
+				builder.WriteByte(byte(b))
+				i += 3
+				continue
+			}
+			builder.WriteByte(escaped[i])
+		default:
+			builder.WriteByte(escaped[i])
+		}
+	}
+
+	return builder.String()
+}
+
+// rootUser is who system units belong to, which the system manager resolves specifiers for
+// without consulting the user database.
+var rootUser = &user.User{Uid: "0", Gid: "0", Username: "root", HomeDir: "/root"}
+
+// owner returns the user whose systemd instance runs the unit, which is root for system units.
+func (unit *Unit) owner() *user.User {
+	if unit.account != nil {
+		return unit.account
+	}
+	if len(unit.User) == 0 {
+		return rootUser
+	}
+
+	owner, err := user.Lookup(unit.User)
+	if err != nil {
+		return nil
+	}
+
+	return owner
+}
@@ -4,52 +4,454 @@ Package lansrv: These functions are built to work with systemd.
 package lansrv
 
 import (
+	"bufio"
 	"fmt"
+	"net"
 	"os"
+	"os/user"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+)
 
-	"github.com/zieckey/goini"
+const (
+	lanSrvSection = "LanSrv"
+	// autoPort as a Port value advertises whatever ports the unit is listening on.
+	autoPort = "auto"
 )
 
-// GatherServiceConfigs accepts @arg dir which it will walk recusively and collect an array
-// of file names that contain a lansrv config.  Lansrv is built to work with systemd so any file
-// ending with `.service` will be included in the array.
-func GatherServiceConfigs(dir string) (configFiles []string) {
-	filepath.Walk(dir, func(path string, info os.FileInfo, _ error) error {
-		if strings.HasSuffix(info.Name(), ".service") {
-			configFiles = append(configFiles, path)
+// SystemUnitPaths lists the systemd system unit directories from highest to lowest precedence.
+var SystemUnitPaths = []string{
+	"/etc/systemd/system",
+	"/run/systemd/system",
+	"/usr/local/lib/systemd/system",
+	"/usr/lib/systemd/system",
+	"/lib/systemd/system",
+}
+
+var unitSuffixes = []string{".service", ".socket"}
+
+// UnitFile is a systemd unit resolved across the unit search path along with the drop-in
+// files that extend it, in the order systemd applies them.
+type UnitFile struct {
+	Name    string
+	Path    string
+	DropIns []string
+	// User owning the unit when it was found in a user's systemd instance.
+	User string
+
+	account *user.User
+}
+
+// GatherServiceConfigs accepts @arg dirs, ordered from highest to lowest precedence, which it
+// will walk recursively to collect the unit files that may contain a lansrv config.  Lansrv is
+// built to work with systemd so any file ending with `.service` or `.socket` will be included.  A unit found in
+// an earlier directory hides units of the same name in later ones and units linked to /dev/null
+// are treated as masked.  Drop-ins from `<unit>.d/*.conf` in any of the directories are attached
+// sorted by file name, again letting earlier directories override files of the same name.
+//
+// Templates such as `nats@.service` are only included through their instances, which are found
+// from the symlinks in `*.wants` and `*.requires` directories e.g. `nats@a.service`.  Instances
+// use the template file and drop-ins unless they have files of their own.
+func GatherServiceConfigs(dirs ...string) (units []UnitFile) {
+	found := make(map[string]string)
+	dropIns := make(map[string]map[string]string)
+	names := []string{}
+	instances := make(map[string]bool)
+
+	for _, dir := range dirs {
+		filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return nil
+			}
+
+			parent := filepath.Base(filepath.Dir(path))
+			switch {
+			case strings.HasSuffix(parent, ".d") && strings.HasSuffix(info.Name(), ".conf"):
+				unitName := strings.TrimSuffix(parent, ".d")
+				if !isUnitName(unitName) {
+					return nil
+				}
+
+				if _, ok := dropIns[unitName]; !ok {
+					dropIns[unitName] = make(map[string]string)
+				}
+				if _, ok := dropIns[unitName][info.Name()]; !ok {
+					dropIns[unitName][info.Name()] = path
+				}
+			case isUnitName(info.Name()) && isDependencyDir(parent):
+				if _, instance := splitUnitName(info.Name()); len(instance) > 0 && !instances[info.Name()] {
+					instances[info.Name()] = true
+					names = append(names, info.Name())
+				}
+			case isUnitName(info.Name()):
+				if _, ok := found[info.Name()]; !ok {
+					found[info.Name()] = path
+					names = append(names, info.Name())
+				}
+			}
+
+			return nil
+		})
+	}
+
+	listed := make(map[string]bool)
+	for _, name := range names {
+		if listed[name] {
+			continue
 		}
+		listed[name] = true
 
-		return nil
-	})
+		prefix, instance := splitUnitName(name)
+		template := prefix + "@" + filepath.Ext(name)
+		if name == template {
+			continue
+		}
+
+		unit := UnitFile{Name: name, Path: found[name]}
+		confs := make(map[string]string)
+		if len(instance) > 0 {
+			templatePath, ok := found[template]
+			if ok && isMasked(templatePath) {
+				continue
+			}
+			if len(unit.Path) == 0 {
+				unit.Path = templatePath
+			}
+
+			for confName, path := range dropIns[template] {
+				confs[confName] = path
+			}
+		}
+		if isMasked(unit.Path) {
+			continue
+		}
+
+		for confName, path := range dropIns[name] {
+			confs[confName] = path
+		}
+
+		confNames := []string{}
+		for confName := range confs {
+			confNames = append(confNames, confName)
+		}
+		sort.Strings(confNames)
+
+		for _, confName := range confNames {
+			unit.DropIns = append(unit.DropIns, confs[confName])
+		}
+
+		units = append(units, unit)
+	}
 
 	return
 }
 
-// ParseServiceConfigs takes @arg configs (list of filenames), tries to parse them
-// as ini files and returns all non-nil results for lansrv configurations containing at least a Name
+// ParseServiceFiles takes @arg units, tries to parse them along with their drop-ins as systemd
+// unit files and returns all non-nil results for lansrv configurations containing at least a Name
 // and a Port.
-func ParseServiceFiles(configFiles []string) []LanAd {
+//
+// Setting `Port=auto` advertises every port the unit's processes are listening on at the time of
+// parsing, see UnitListeners.
+//
+// Besides [LanSrv] a unit may hold any number of named sections like [LanSrv "metrics"] or
+// [LanSrv.cluster], each producing its own ad.  When a section doesn't set a Name the unit name is
+// used, followed by the section name for named sections e.g. `nats-cluster`.
+func ParseServiceFiles(units []UnitFile) []LanAd {
 	configs := []LanAd{}
 
-	for _, configFile := range configFiles {
-		ini := goini.New()
-		if err := ini.ParseFile(configFile); err != nil {
+	for _, file := range units {
+		unit, err := ParseUnitFile(file)
+		if err != nil {
 			continue
 		}
 
-		adMap, ok := ini.GetKvmap("LanSrv")
-		if !ok {
+		sections := unit.lanSrvSections()
+		if len(sections) == 0 {
 			continue
 		}
 
-		ad := new(LanAd)
-		if err := ad.FromMap(adMap); err == nil {
-			fmt.Println("adding lan ad:", adMap)
-			configs = append(configs, *ad)
+		env, err := unit.Environment()
+		if err != nil {
+			fmt.Println("could not read environment for", unit.Name+":", err)
+			continue
+		}
+
+		for _, section := range sections {
+			adMap, _ := unit.Section(section)
+			for key, value := range adMap {
+				adMap[key] = ExpandEnvironment(unit.ExpandSpecifiers(value), env)
+			}
+
+			_, hasName := adMap["Name"]
+			if _, hasService := adMap["Service"]; !hasName && !hasService {
+				adMap["Name"] = strings.TrimSuffix(unit.Name, filepath.Ext(unit.Name))
+				if adName := lanSrvSectionName(section); len(adName) > 0 {
+					adMap["Name"] += "-" + adName
+				}
+			}
+
+			if _, hasPort := adMap["Port"]; !hasPort {
+				if port, transport, ok := unit.socketListener(); ok {
+					adMap["Port"] = strconv.Itoa(port)
+					if _, hasTransport := adMap["Transport"]; !hasTransport {
+						adMap["Transport"] = transport
+					}
+				}
+			}
+
+			if adMap["Port"] == autoPort {
+				configs = append(configs, unit.autoPortAds(adMap)...)
+				continue
+			}
+
+			ad := &LanAd{User: unit.User}
+			if err := ad.FromMap(adMap); err == nil {
+				configs = append(configs, *ad)
+			}
 		}
 	}
 
 	return configs
 }
+
+// autoPortAds returns an ad for each port the unit's processes are currently listening on.
+func (unit *Unit) autoPortAds(adMap map[string]string) []LanAd {
+	listeners, err := UnitListeners(unit.Name, unit.User)
+	if err != nil {
+		fmt.Println("could not detect ports for", unit.Name+":", err)
+		return nil
+	}
+
+	ads := []LanAd{}
+	seen := make(map[string]bool)
+	for _, listener := range listeners {
+		key := listener.Transport + "/" + strconv.Itoa(listener.Port)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		listenerMap := map[string]string{"Transport": listener.Transport}
+		for key, value := range adMap {
+			listenerMap[key] = value
+		}
+		listenerMap["Port"] = strconv.Itoa(listener.Port)
+
+		ad := &LanAd{User: unit.User}
+		if err := ad.FromMap(listenerMap); err == nil {
+			ads = append(ads, *ad)
+		}
+	}
+
+	return ads
+}
+
+// socketListener returns the port and transport of the first network listener of a socket unit
+// set with ListenStream= or ListenDatagram=.
+func (unit *Unit) socketListener() (int, string, bool) {
+	if filepath.Ext(unit.Name) != ".socket" {
+		return 0, "", false
+	}
+
+	listeners := []struct{ key, transport string }{
+		{"ListenStream", "tcp"},
+		{"ListenDatagram", "udp"},
+	}
+	for _, listener := range listeners {
+		for _, listen := range unit.Values("Socket", listener.key) {
+			if port, ok := parseListenPort(unit.ExpandSpecifiers(listen)); ok {
+				return port, listener.transport, true
+			}
+		}
+	}
+
+	return 0, "", false
+}
+
+// parseListenPort reads the port from a socket address as accepted by ListenStream= such as
+// `8080`, `0.0.0.0:8080` or `[::]:8080`.  File system and other non-IP sockets are skipped.
+func parseListenPort(listen string) (int, bool) {
+	if colon := strings.LastIndex(listen, ":"); colon > -1 {
+		if net.ParseIP(strings.Trim(listen[:colon], "[]")) == nil {
+			return 0, false
+		}
+		listen = listen[colon+1:]
+	}
+
+	port, err := strconv.Atoi(listen)
+	if err != nil || port <= 0 || port > 65535 {
+		return 0, false
+	}
+
+	return port, true
+}
+
+// lanSrvSections returns the names of all sections in the unit holding a lansrv config, sorted so
+// the plain [LanSrv] section comes first.
+func (unit *Unit) lanSrvSections() []string {
+	sections := []string{}
+	for section := range unit.sections {
+		if section == lanSrvSection || len(lanSrvSectionName(section)) > 0 {
+			sections = append(sections, section)
+		}
+	}
+	sort.Strings(sections)
+
+	return sections
+}
+
+// lanSrvSectionName returns `metrics` for a section named [LanSrv "metrics"] or [LanSrv.metrics]
+// and an empty string for any other section.
+func lanSrvSectionName(section string) string {
+	switch {
+	case strings.HasPrefix(section, lanSrvSection+"."):
+		return strings.TrimPrefix(section, lanSrvSection+".")
+	case strings.HasPrefix(section, lanSrvSection+" "):
+		name := strings.TrimSpace(strings.TrimPrefix(section, lanSrvSection))
+		if len(name) > 2 && name[0] == '"' && name[len(name)-1] == '"' {
+			return name[1 : len(name)-1]
+		}
+	}
+
+	return ""
+}
+
+// Unit holds the merged settings of a unit file and its drop-ins.  Every assignment is kept in
+// order so list settings behave as they do in systemd, including an empty assignment resetting
+// the list.
+type Unit struct {
+	Name     string
+	User     string
+	account  *user.User
+	sections map[string]map[string][]string
+}
+
+// ParseUnitFile reads the unit file and then each of its drop-ins on top of it.
+func ParseUnitFile(file UnitFile) (*Unit, error) {
+	unit := &Unit{Name: file.Name, User: file.User, account: file.account, sections: make(map[string]map[string][]string)}
+
+	for _, path := range append([]string{file.Path}, file.DropIns...) {
+		if err := unit.parseFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	return unit, nil
+}
+
+func (unit *Unit) parseFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	section := ""
+	continued := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasSuffix(line, "\\") {
+			continued += strings.TrimSuffix(line, "\\") + " "
+			continue
+		}
+		line, continued = continued+line, ""
+
+		switch {
+		case len(line) == 0 || line[0] == '#' || line[0] == ';':
+			continue
+		case line[0] == '[' && line[len(line)-1] == ']':
+			section = line[1 : len(line)-1]
+			if _, ok := unit.sections[section]; !ok {
+				unit.sections[section] = make(map[string][]string)
+			}
+			continue
+		}
+
+		eq := strings.Index(line, "=")
+		if eq < 0 || len(section) == 0 {
+			// systemd ignores lines it can't make sense of so we do too
+			continue
+		}
+
+		key, value := strings.TrimSpace(line[:eq]), strings.TrimSpace(line[eq+1:])
+		if len(value) == 0 {
+			delete(unit.sections[section], key)
+			continue
+		}
+		unit.sections[section][key] = append(unit.sections[section][key], value)
+	}
+
+	return scanner.Err()
+}
+
+// Get returns the last value assigned to @arg key in @arg section.
+func (unit *Unit) Get(section, key string) (string, bool) {
+	values := unit.Values(section, key)
+	if len(values) == 0 {
+		return "", false
+	}
+
+	return values[len(values)-1], true
+}
+
+// Values returns every value assigned to @arg key in @arg section.
+func (unit *Unit) Values(section, key string) []string {
+	return unit.sections[section][key]
+}
+
+// Section returns a map of each key in @arg section to the last value assigned to it.
+func (unit *Unit) Section(section string) (map[string]string, bool) {
+	keys, ok := unit.sections[section]
+	if !ok {
+		return nil, false
+	}
+
+	kvmap := make(map[string]string, len(keys))
+	for key, values := range keys {
+		kvmap[key] = values[len(values)-1]
+	}
+
+	return kvmap, true
+}
+
+func isUnitName(name string) bool {
+	for _, suffix := range unitSuffixes {
+		if strings.HasSuffix(name, suffix) && len(name) > len(suffix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isDependencyDir reports whether @arg dir holds dependency symlinks like multi-user.target.wants
+// rather than unit definitions.
+func isDependencyDir(dir string) bool {
+	return strings.HasSuffix(dir, ".wants") || strings.HasSuffix(dir, ".requires")
+}
+
+// splitUnitName splits a unit name like `nats@a.service` into its prefix `nats` and instance `a`.
+func splitUnitName(name string) (prefix, instance string) {
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+	if at := strings.Index(name, "@"); at > -1 {
+		return name[:at], name[at+1:]
+	}
+
+	return name, ""
+}
+
+func isMasked(path string) bool {
+	target, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return true
+	}
+	if target == os.DevNull {
+		return true
+	}
+
+	info, err := os.Stat(target)
+	return err != nil || info.Size() == 0
+}
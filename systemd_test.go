@@ -0,0 +1,169 @@
+package lansrv
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeUnitFile(t *testing.T, path, contents string) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGatherServiceConfigsPrecedence(t *testing.T) {
+	root := t.TempDir()
+	etc, vendor := filepath.Join(root, "etc"), filepath.Join(root, "usr")
+
+	writeUnitFile(t, filepath.Join(vendor, "files.service"), "[Service]\nExecStart=/bin/files\n")
+	writeUnitFile(t, filepath.Join(vendor, "files.service.d", "10-lansrv.conf"), "[LanSrv]\nService=files\nPort=8000\n")
+	writeUnitFile(t, filepath.Join(etc, "files.service.d", "20-port.conf"), "[LanSrv]\nPort=9000\n")
+
+	writeUnitFile(t, filepath.Join(vendor, "nats.service"), "[LanSrv]\nService=vendor-nats\nPort=4222\n")
+	writeUnitFile(t, filepath.Join(etc, "nats.service"), "[LanSrv]\nService=nats-node\nPort=4222\nProtocol=nats\n")
+
+	writeUnitFile(t, filepath.Join(vendor, "masked.service"), "[LanSrv]\nService=masked\nPort=1\n")
+	if err := os.Symlink(os.DevNull, filepath.Join(etc, "masked.service")); err != nil {
+		t.Fatal(err)
+	}
+
+	units := GatherServiceConfigs(etc, vendor)
+	assert.Len(t, units, 2)
+
+	ads := ParseServiceFiles(units)
+	assert.ElementsMatch(t, []LanAd{
+		{Service: "files", Port: 9000, Protocol: "http"},
+		{Service: "nats-node", Port: 4222, Protocol: "nats"},
+	}, ads)
+}
+
+func TestParseUnitFileResetsLists(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "svc.service")
+	writeUnitFile(t, path, "[LanSrv]\nService=svc\nPath=/a\n# comment\nPort=\\\n  80\n")
+	dropIn := filepath.Join(dir, "svc.service.d", "override.conf")
+	writeUnitFile(t, dropIn, "[LanSrv]\nPath=\n")
+
+	unit, err := ParseUnitFile(UnitFile{Name: "svc.service", Path: path, DropIns: []string{dropIn}})
+	assert.NoError(t, err)
+
+	port, _ := unit.Get("LanSrv", "Port")
+	assert.Equal(t, "80", port)
+	_, ok := unit.Get("LanSrv", "Path")
+	assert.False(t, ok)
+}
+
+func TestGatherServiceConfigsTemplates(t *testing.T) {
+	dir := t.TempDir()
+
+	template := filepath.Join(dir, "nats@.service")
+	writeUnitFile(t, template, "[LanSrv]\nName=nats-%i\nPort=42%i\nPath=/%I\n")
+	writeUnitFile(t, filepath.Join(dir, "nats@.service.d", "protocol.conf"), "[LanSrv]\nProtocol=nats\n")
+	writeUnitFile(t, filepath.Join(dir, "nats@2.service.d", "path.conf"), "[LanSrv]\nPath=/%n\n")
+	for _, instance := range []string{"nats@1.service", "nats@2.service"} {
+		link := filepath.Join(dir, "multi-user.target.wants", instance)
+		if err := os.MkdirAll(filepath.Dir(link), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(template, link); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	units := GatherServiceConfigs(dir)
+	assert.Len(t, units, 2)
+
+	ads := ParseServiceFiles(units)
+	assert.ElementsMatch(t, []LanAd{
+		{Service: "nats-1", Port: 421, Path: "/1", Protocol: "nats"},
+		{Service: "nats-2", Port: 422, Path: "/nats@2.service", Protocol: "nats"},
+	}, ads)
+}
+
+func TestExpandSpecifiers(t *testing.T) {
+	unit := &Unit{Name: `web-files@srv-http\x2dfiles.service`}
+
+	assert.Equal(t, "web-files", unit.ExpandSpecifiers("%p"))
+	assert.Equal(t, "files", unit.ExpandSpecifiers("%j"))
+	assert.Equal(t, "srv/http-files", unit.ExpandSpecifiers("%I"))
+	assert.Equal(t, `web-files@srv-http\x2dfiles`, unit.ExpandSpecifiers("%N"))
+	assert.Equal(t, "100%", unit.ExpandSpecifiers("100%%"))
+	assert.Equal(t, "%z", unit.ExpandSpecifiers("%z"))
+	// system units belong to root whoever runs lansrv
+	assert.Equal(t, "root 0 /root", unit.ExpandSpecifiers("%u %U %h"))
+}
+
+func TestParseServiceFilesEnvironment(t *testing.T) {
+	dir := t.TempDir()
+	envFile := filepath.Join(dir, "api.env")
+	writeUnitFile(t, envFile, "# api settings\nPORT=8081\nAPI_PATH=\"/v1\"\n")
+	writeUnitFile(t, filepath.Join(dir, "api.service"), `[Service]
+Environment="PROTO=https" PORT=8000
+EnvironmentFile=`+envFile+`
+EnvironmentFile=-`+filepath.Join(dir, "missing.env")+`
+
+[LanSrv]
+Name=api
+Port=${PORT}
+Path=$API_PATH
+Protocol=${PROTO}
+`)
+
+	ads := ParseServiceFiles(GatherServiceConfigs(dir))
+	assert.Equal(t, []LanAd{{Service: "api", Port: 8081, Path: "/v1", Protocol: "https"}}, ads)
+}
+
+func TestParseServiceFilesNamedSections(t *testing.T) {
+	dir := t.TempDir()
+	writeUnitFile(t, filepath.Join(dir, "nats.service"), `[LanSrv]
+Port=4222
+Protocol=nats
+
+[LanSrv "metrics"]
+Port=8222
+
+[LanSrv.cluster]
+Name=nats-node
+Port=6222
+Protocol=nats
+`)
+
+	ads := ParseServiceFiles(GatherServiceConfigs(dir))
+	assert.Equal(t, []LanAd{
+		{Service: "nats", Port: 4222, Protocol: "nats"},
+		{Service: "nats-metrics", Port: 8222, Protocol: "http"},
+		{Service: "nats-node", Port: 6222, Protocol: "nats"},
+	}, ads)
+}
+
+func TestParseServiceFilesSockets(t *testing.T) {
+	dir := t.TempDir()
+	writeUnitFile(t, filepath.Join(dir, "cups.socket"), `[Socket]
+ListenStream=/run/cups/cups.sock
+ListenStream=[::]:631
+
+[LanSrv]
+Name=printer
+Protocol=ipp
+`)
+	writeUnitFile(t, filepath.Join(dir, "syslog.socket"), `[Socket]
+ListenDatagram=0.0.0.0:514
+
+[LanSrv]
+Protocol=syslog
+`)
+	writeUnitFile(t, filepath.Join(dir, "unix.socket"), "[Socket]\nListenStream=/run/unix.sock\n\n[LanSrv]\nName=unix\n")
+
+	ads := ParseServiceFiles(GatherServiceConfigs(dir))
+	assert.ElementsMatch(t, []LanAd{
+		{Service: "printer", Port: 631, Protocol: "ipp", Transport: "tcp"},
+		{Service: "syslog", Port: 514, Protocol: "syslog", Transport: "udp"},
+	}, ads)
+}
@@ -0,0 +1,244 @@
+package lansrv
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/template"
+)
+
+// TemplateFuncs returns the functions available to the templates of a TemplateSink, which render
+// a ServiceSet:
+//
+//	services               the sorted names of all discovered services
+//	hosts                  the sorted hosts services were discovered on
+//	service NAME           the ads of NAME on every host, sorted by address and port
+//	protocol PROTO ADS     the ads in ADS using PROTO
+//	endpoints NAME FORMAT  the sorted endpoints of NAME formatted like ToFormattedString
+//	format FORMAT AD       AD formatted like ToFormattedString
+//	join SEP LIST          the strings in LIST separated by SEP
+func TemplateFuncs(services ServiceSet) template.FuncMap {
+	return template.FuncMap{
+		"services": func() []string {
+			unique := make(map[string]bool)
+			for _, ads := range services {
+				for _, ad := range ads {
+					unique[ad.Service] = true
+				}
+			}
+			return sortedKeys(unique)
+		},
+		"hosts": func() []string {
+			unique := make(map[string]bool)
+			for host := range services {
+				unique[host] = true
+			}
+			return sortedKeys(unique)
+		},
+		"service": func(name string) []LanAd {
+			return ServiceAds(services, name)
+		},
+		"protocol": func(protocol string, ads []LanAd) []LanAd {
+			matching := []LanAd{}
+			for _, ad := range ads {
+				if ad.Protocol == protocol {
+					matching = append(matching, ad)
+				}
+			}
+			return matching
+		},
+		"endpoints": func(name, format string) []string {
+			return Endpoints(services, name, format)
+		},
+		"format": func(format string, ad LanAd) string {
+			return ad.ToFormattedString(format)
+		},
+		"join": func(sep string, list []string) string {
+			return strings.Join(list, sep)
+		},
+	}
+}
+
+// ServiceAds returns the ads for @arg service found on every host in @arg services, sorted by
+// address and port.
+func ServiceAds(services ServiceSet, service string) []LanAd {
+	ads := []LanAd{}
+	for _, hostAds := range services {
+		for _, ad := range hostAds {
+			if ad.Service == service {
+				ads = append(ads, ad)
+			}
+		}
+	}
+
+	sort.Slice(ads, func(i, j int) bool {
+		if c := bytes.Compare(ads[i].Address.To16(), ads[j].Address.To16()); c != 0 {
+			return c < 0
+		}
+		return ads[i].Port < ads[j].Port
+	})
+
+	return ads
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// TemplateSink renders a text/template over the discovered services to Destination whenever its
+// output changes, then runs Command or sends Signal to the process in PidFile so the program
+// reading the file reloads it.  A reload that fails is tried again after each scan of a Watcher
+// until it succeeds.
+type TemplateSink struct {
+	Template    *template.Template
+	Destination string
+	Perm        os.FileMode
+	Command     string
+	PidFile     string
+	Signal      syscall.Signal
+
+	pending bool
+}
+
+// NewTemplateSink parses the template at @arg source.  Its functions are TemplateFuncs.
+func NewTemplateSink(source, destination string) (*TemplateSink, error) {
+	tmpl, err := template.New(filepath.Base(source)).Funcs(TemplateFuncs(nil)).ParseFiles(source)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TemplateSink{Template: tmpl, Destination: destination, Perm: 0644, Signal: syscall.SIGHUP}, nil
+}
+
+func (sink *TemplateSink) Update(services ServiceSet, _ []ServiceEvent) error {
+	tmpl, err := sink.Template.Clone()
+	if err != nil {
+		return err
+	}
+
+	output := &bytes.Buffer{}
+	if err := tmpl.Funcs(TemplateFuncs(services)).Execute(output, services); err != nil {
+		return err
+	}
+
+	changed, err := WriteFileAtomic(sink.Destination, output.Bytes(), sink.Perm)
+	if err != nil {
+		return err
+	}
+	if !changed && !sink.pending {
+		return nil
+	}
+
+	// the file is already written, so an unchanged render next time must still reload it
+	err = sink.reload()
+	sink.pending = err != nil
+
+	return err
+}
+
+// scanned retries a reload that failed, since a quiet network may not update the sink again.
+func (sink *TemplateSink) scanned() error {
+	if !sink.pending {
+		return nil
+	}
+
+	err := sink.reload()
+	sink.pending = err != nil
+
+	return err
+}
+
+// reload runs Command or sends Signal to the process in PidFile.
+func (sink *TemplateSink) reload() error {
+	switch {
+	case len(sink.Command) > 0:
+		cmd := exec.Command("sh", "-c", sink.Command)
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("%s: %v", sink.Command, err)
+		}
+	case len(sink.PidFile) > 0:
+		return SignalPidFile(sink.PidFile, sink.Signal)
+	}
+
+	return nil
+}
+
+// SignalPidFile sends @arg sig to the process whose pid is written in @arg path.
+func SignalPidFile(path string, sig syscall.Signal) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		return fmt.Errorf("%s: invalid pid %q", path, strings.TrimSpace(string(data)))
+	}
+
+	return syscall.Kill(pid, sig)
+}
+
+// signals are the names accepted by ParseSignal.
+var signals = map[string]syscall.Signal{
+	"HUP":  syscall.SIGHUP,
+	"INT":  syscall.SIGINT,
+	"TERM": syscall.SIGTERM,
+	"QUIT": syscall.SIGQUIT,
+	"USR1": syscall.SIGUSR1,
+	"USR2": syscall.SIGUSR2,
+}
+
+// ParseSignal returns the signal named @arg name, e.g. `HUP` or `SIGUSR1`.
+func ParseSignal(name string) (syscall.Signal, error) {
+	sig, ok := signals[strings.TrimPrefix(strings.ToUpper(name), "SIG")]
+	if !ok {
+		return 0, errors.New("unknown signal " + name)
+	}
+
+	return sig, nil
+}
+
+// newTemplateSink takes the template `src`, the `dest` to render it to and either the `cmd` to
+// run or the `pidfile` to send `signal` (HUP by default) to when it changes.  `perm` sets the
+// octal mode of the file.
+func newTemplateSink(options map[string]string) (Sink, error) {
+	if len(options["src"]) == 0 || len(options["dest"]) == 0 {
+		return nil, errors.New("src and dest are required")
+	}
+
+	sink, err := NewTemplateSink(options["src"], options["dest"])
+	if err != nil {
+		return nil, err
+	}
+	sink.Command, sink.PidFile = options["cmd"], options["pidfile"]
+
+	if name, ok := options["signal"]; ok {
+		if sink.Signal, err = ParseSignal(name); err != nil {
+			return nil, err
+		}
+	}
+	if perm, ok := options["perm"]; ok {
+		mode, err := strconv.ParseUint(perm, 8, 32)
+		if err != nil {
+			return nil, errors.New("invalid perm " + perm)
+		}
+		sink.Perm = os.FileMode(mode)
+	}
+
+	return sink, nil
+}
@@ -0,0 +1,150 @@
+package lansrv
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTemplateSink(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "upstreams.tmpl")
+	ioutil.WriteFile(src, []byte(`{{range services}}upstream {{.}} {
+{{range service .}}  server {{format "%addr%:%port%" .}};
+{{end}}}
+{{end}}routes = [{{join ", " (endpoints "nats-node" "nats://%addr%:%port%")}}]
+{{len (protocol "nats" (service "nats-node"))}} nats
+`), 0644)
+
+	dest := filepath.Join(dir, "upstreams.conf")
+	reloads := filepath.Join(dir, "reloads")
+	sink, err := NewSink("template:src=" + src + ",dest=" + dest + ",cmd=echo reload >> " + reloads)
+	assert.NoError(t, err)
+
+	services := ServiceSet{
+		"10.0.0.3": {{Service: "files", Address: net.ParseIP("10.0.0.3"), Port: 9999, Protocol: "http"}},
+		"10.0.0.2": {
+			{Service: "nats-node", Address: net.ParseIP("10.0.0.2"), Port: 4222, Protocol: "nats"},
+			{Service: "files", Address: net.ParseIP("10.0.0.2"), Port: 9999, Protocol: "http"},
+		},
+	}
+	assert.NoError(t, sink.Update(services, nil))
+	assert.NoError(t, sink.Update(services, nil))
+
+	rendered, _ := ioutil.ReadFile(dest)
+	assert.Equal(t, `upstream files {
+  server 10.0.0.2:9999;
+  server 10.0.0.3:9999;
+}
+upstream nats-node {
+  server 10.0.0.2:4222;
+}
+routes = [nats://10.0.0.2:4222]
+1 nats
+`, string(rendered))
+
+	// the command only runs when the output changes
+	ran, _ := ioutil.ReadFile(reloads)
+	assert.Equal(t, "reload\n", string(ran))
+}
+
+func TestTemplateSinkSignal(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "hosts.tmpl")
+	ioutil.WriteFile(src, []byte(`{{join "\n" hosts}}`), 0644)
+	pidFile := filepath.Join(dir, "app.pid")
+	ioutil.WriteFile(pidFile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644)
+
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, syscall.SIGUSR1)
+	defer signal.Stop(sigc)
+
+	sink, err := NewSink("template:src=" + src + ",dest=" + filepath.Join(dir, "hosts") + ",pidfile=" + pidFile + ",signal=USR1")
+	assert.NoError(t, err)
+	assert.NoError(t, sink.Update(ServiceSet{"10.0.0.2": nil}, nil))
+
+	select {
+	case sig := <-sigc:
+		assert.Equal(t, syscall.SIGUSR1, sig)
+	case <-time.After(2 * time.Second):
+		t.Fatal("no signal received")
+	}
+}
+
+func TestTemplateSinkRetriesReload(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "hosts.tmpl")
+	ioutil.WriteFile(src, []byte(`{{join "\n" hosts}}`), 0644)
+	reloads := filepath.Join(dir, "reloads")
+	ready := filepath.Join(dir, "ready")
+
+	// the command fails until the ready file exists
+	sink, err := NewSink("template:src=" + src + ",dest=" + filepath.Join(dir, "hosts") +
+		",cmd=test -e " + ready + " && echo reload >> " + reloads)
+	assert.NoError(t, err)
+
+	services := ServiceSet{"10.0.0.2": nil}
+	assert.Error(t, sink.Update(services, nil))
+
+	ioutil.WriteFile(ready, nil, 0644)
+	assert.NoError(t, sink.Update(services, nil))
+	assert.NoError(t, sink.Update(services, nil))
+
+	ran, _ := ioutil.ReadFile(reloads)
+	assert.Equal(t, "reload\n", string(ran))
+}
+
+func TestTemplateSinkRetriesReloadOnScans(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "hosts.tmpl")
+	ioutil.WriteFile(src, []byte(`{{join "\n" hosts}}`), 0644)
+	reloads := filepath.Join(dir, "reloads")
+	ready := filepath.Join(dir, "ready")
+
+	sink, err := NewSink("template:src=" + src + ",dest=" + filepath.Join(dir, "hosts") +
+		",cmd=test -e " + ready + " && echo reload >> " + reloads)
+	assert.NoError(t, err)
+
+	// every scan finds the same services, so the sink is only updated once
+	watcher := &Watcher{
+		Sinks:    []Sink{sink},
+		Interval: 10 * time.Millisecond,
+		Timeout:  time.Second,
+		Lookup: func(context.Context, bool) (ServiceSet, error) {
+			return ServiceSet{"10.0.0.2": nil}, nil
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		watcher.Run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	ioutil.WriteFile(ready, nil, 0644)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if ran, _ := ioutil.ReadFile(reloads); len(ran) > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	ran, _ := ioutil.ReadFile(reloads)
+	assert.Equal(t, "reload\n", string(ran))
+}
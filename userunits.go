@@ -0,0 +1,62 @@
+package lansrv
+
+import (
+	"io/ioutil"
+	"os/user"
+	"path/filepath"
+	"strconv"
+)
+
+// UserRuntimeDir holds a runtime directory named by uid for every user logged in through
+// systemd-logind.
+var UserRuntimeDir = "/run/user"
+
+// UserUnitPaths lists the unit directories of @arg owner's systemd instance from highest to lowest
+// precedence.
+func UserUnitPaths(owner *user.User) []string {
+	runtime := filepath.Join(UserRuntimeDir, owner.Uid, "systemd")
+
+	return []string{
+		filepath.Join(owner.HomeDir, ".config", "systemd", "user"),
+		"/etc/systemd/user",
+		filepath.Join(runtime, "user"),
+		"/run/systemd/user",
+		filepath.Join(owner.HomeDir, ".local", "share", "systemd", "user"),
+		"/usr/local/lib/systemd/user",
+		"/usr/lib/systemd/user",
+	}
+}
+
+// GatherUserServiceConfigs collects the units of @arg owner's systemd instance the same way
+// GatherServiceConfigs does for system units, marking each with the owning user.
+func GatherUserServiceConfigs(owner *user.User) []UnitFile {
+	units := GatherServiceConfigs(UserUnitPaths(owner)...)
+	for i := range units {
+		units[i].User = owner.Username
+		units[i].account = owner
+	}
+
+	return units
+}
+
+// LoggedInUsers returns the users that currently have a runtime directory, which systemd-logind
+// creates for each logged in user.
+func LoggedInUsers() []*user.User {
+	entries, err := ioutil.ReadDir(UserRuntimeDir)
+	if err != nil {
+		return nil
+	}
+
+	users := []*user.User{}
+	for _, entry := range entries {
+		if _, err := strconv.Atoi(entry.Name()); err != nil || !entry.IsDir() {
+			continue
+		}
+
+		if owner, err := user.LookupId(entry.Name()); err == nil {
+			users = append(users, owner)
+		}
+	}
+
+	return users
+}
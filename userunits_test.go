@@ -0,0 +1,51 @@
+package lansrv
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGatherUserServiceConfigs(t *testing.T) {
+	current, err := user.Current()
+	if err != nil {
+		t.Skip("no current user:", err)
+	}
+
+	owner := *current
+	owner.HomeDir = t.TempDir()
+	writeUnitFile(t, filepath.Join(owner.HomeDir, ".config", "systemd", "user", "notes.service"),
+		"[LanSrv]\nName=notes-%u\nPort=8080\nPath=%h\n")
+
+	ads := ParseServiceFiles(GatherUserServiceConfigs(&owner))
+	assert.Contains(t, ads, LanAd{
+		Service:  "notes-" + owner.Username,
+		Port:     8080,
+		Path:     owner.HomeDir,
+		Protocol: "http",
+		User:     owner.Username,
+	})
+}
+
+func TestLoggedInUsers(t *testing.T) {
+	current, err := user.Current()
+	if err != nil {
+		t.Skip("no current user:", err)
+	}
+
+	defer func(dir string) { UserRuntimeDir = dir }(UserRuntimeDir)
+	UserRuntimeDir = t.TempDir()
+	for _, dir := range []string{current.Uid, "not-a-uid"} {
+		if err := os.Mkdir(filepath.Join(UserRuntimeDir, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	users := LoggedInUsers()
+	if assert.Len(t, users, 1) {
+		assert.Equal(t, current.Username, users[0].Username)
+	}
+}
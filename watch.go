@@ -0,0 +1,194 @@
+package lansrv
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// ServiceSet maps each host to the ads discovered on it, as returned by ServicesLookup.
+type ServiceSet = map[string][]LanAd
+
+// ChangeType describes how an ad changed between two scans.
+type ChangeType string
+
+const (
+	Added   ChangeType = "added"
+	Removed ChangeType = "removed"
+	Updated ChangeType = "updated"
+)
+
+// ServiceEvent is a change to an ad published by Host.
+type ServiceEvent struct {
+	Type ChangeType `json:"type"`
+	Host string     `json:"host"`
+	Ad   LanAd      `json:"ad"`
+}
+
+// Sink consumes the services discovered by a Watcher.
+type Sink interface {
+	// Update receives the full set of services after a scan along with the changes since the
+	// previous update.  The first update lists every service as added.
+	Update(services ServiceSet, events []ServiceEvent) error
+}
+
+// scanSink is implemented by sinks that also need to know of the scans that found no changes.
+type scanSink interface {
+	// scanned is called after a scan that didn't update the sinks.
+	scanned() error
+}
+
+// Watcher scans the network repeatedly and feeds the discovered services to its sinks whenever
+// they change.
+type Watcher struct {
+	Sinks []Sink
+	// Interval between the start of two scans and Timeout of each scan.
+	Interval time.Duration
+	Timeout  time.Duration
+	// Localhost includes services published by this host.
+	Localhost bool
+	// Misses is the number of scans in a row an ad must be missing from before it is removed, which
+	// rides out the occasional lost mDNS response.  Ads are removed as soon as they are missing
+	// when it is less than 1.
+	Misses int
+	// Lookup is used to scan the network, ServicesLookup when nil.
+	Lookup func(ctx context.Context, localhost bool) (ServiceSet, error)
+}
+
+// Run scans until @arg ctx is done.  Sink errors are logged and don't stop the watcher.
+func (watcher *Watcher) Run(ctx context.Context) error {
+	lookup := watcher.Lookup
+	if lookup == nil {
+		lookup = ServicesLookup
+	}
+
+	current := make(ServiceSet)
+	missed := make(map[string]int)
+	first := true
+
+	for {
+		started := time.Now()
+		scanCtx, cancel := context.WithTimeout(ctx, watcher.Timeout)
+		found, err := lookup(scanCtx, watcher.Localhost)
+		cancel()
+
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		if err != nil {
+			fmt.Println("Failed to lookup services:", err)
+		} else {
+			next := holdMissing(current, found, missed, watcher.Misses)
+			events := DiffServices(current, next)
+			current = next
+
+			update := first || len(events) > 0
+			first = false
+			for _, sink := range watcher.Sinks {
+				var err error
+				if update {
+					err = sink.Update(current, events)
+				} else if scanned, ok := sink.(scanSink); ok {
+					err = scanned.scanned()
+				}
+				if err != nil {
+					fmt.Println("Sink failed:", err)
+				}
+			}
+		}
+
+		select {
+		case <-time.After(watcher.Interval - time.Since(started)):
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// holdMissing adds the ads of @arg current that aren't in @arg found back into the result until
+// they've been missing for @arg misses scans in a row, counted in @arg missed.
+func holdMissing(current, found ServiceSet, missed map[string]int, misses int) ServiceSet {
+	next := make(ServiceSet)
+	seen := make(map[string]bool)
+	for host, ads := range found {
+		next[host] = append([]LanAd{}, ads...)
+		for _, ad := range ads {
+			seen[adKey(host, ad)] = true
+		}
+	}
+
+	for host, ads := range current {
+		for _, ad := range ads {
+			key := adKey(host, ad)
+			if seen[key] {
+				delete(missed, key)
+				continue
+			}
+
+			missed[key]++
+			if missed[key] < misses {
+				next[host] = append(next[host], ad)
+			} else {
+				delete(missed, key)
+			}
+		}
+	}
+
+	return next
+}
+
+// DiffServices returns the changes from @arg before to @arg after sorted by host, service and port.
+// Ads are matched on their service, port, protocol and transport and reported as updated when any
+// other field changed.
+func DiffServices(before, after ServiceSet) []ServiceEvent {
+	index := func(set ServiceSet) map[string]ServiceEvent {
+		ads := make(map[string]ServiceEvent)
+		for host, hostAds := range set {
+			for _, ad := range hostAds {
+				ads[adKey(host, ad)] = ServiceEvent{Host: host, Ad: ad}
+			}
+		}
+		return ads
+	}
+	old, updated := index(before), index(after)
+
+	events := []ServiceEvent{}
+	for key, event := range updated {
+		previous, existed := old[key]
+		switch {
+		case !existed:
+			event.Type = Added
+		case !SameAds([]LanAd{previous.Ad}, []LanAd{event.Ad}):
+			event.Type = Updated
+		default:
+			continue
+		}
+		events = append(events, event)
+	}
+	for key, event := range old {
+		if _, exists := updated[key]; !exists {
+			event.Type = Removed
+			events = append(events, event)
+		}
+	}
+
+	sort.Slice(events, func(i, j int) bool {
+		a, b := events[i], events[j]
+		if a.Host != b.Host {
+			return a.Host < b.Host
+		}
+		if a.Ad.Service != b.Ad.Service {
+			return a.Ad.Service < b.Ad.Service
+		}
+		return a.Ad.Port < b.Ad.Port
+	})
+
+	return events
+}
+
+func adKey(host string, ad LanAd) string {
+	return host + "|" + ad.Service + "|" + strconv.Itoa(ad.Port) + "|" + ad.Protocol + "|" + ad.transport()
+}
@@ -0,0 +1,86 @@
+package lansrv
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingSink sends every update it receives to its channel.
+type recordingSink chan []ServiceEvent
+
+func (sink recordingSink) Update(_ ServiceSet, events []ServiceEvent) error {
+	sink <- events
+	return nil
+}
+
+func receiveEvents(t *testing.T, sink recordingSink) []ServiceEvent {
+	select {
+	case events := <-sink:
+		return events
+	case <-time.After(2 * time.Second):
+		t.Fatal("no update received")
+		return nil
+	}
+}
+
+func TestDiffServices(t *testing.T) {
+	files := LanAd{Service: "files", Address: net.ParseIP("10.0.0.2"), Port: 9999, Protocol: "http"}
+	moved := files
+	moved.Path = "/share"
+	nats := LanAd{Service: "nats-node", Address: net.ParseIP("10.0.0.3"), Port: 4222, Protocol: "nats"}
+
+	events := DiffServices(
+		ServiceSet{"10.0.0.2": {files}, "10.0.0.3": {nats}},
+		ServiceSet{"10.0.0.2": {moved}, "10.0.0.4": {nats}},
+	)
+
+	assert.Equal(t, []ServiceEvent{
+		{Type: Updated, Host: "10.0.0.2", Ad: moved},
+		{Type: Removed, Host: "10.0.0.3", Ad: nats},
+		{Type: Added, Host: "10.0.0.4", Ad: nats},
+	}, events)
+	assert.Empty(t, DiffServices(ServiceSet{"10.0.0.2": {files}}, ServiceSet{"10.0.0.2": {files}}))
+}
+
+func TestWatcherRun(t *testing.T) {
+	files := LanAd{Service: "files", Port: 9999, Protocol: "http"}
+	scans := make(chan ServiceSet, 4)
+	scans <- ServiceSet{"10.0.0.2": {files}}
+	scans <- ServiceSet{"10.0.0.2": {files}}
+	scans <- ServiceSet{}
+	scans <- ServiceSet{}
+
+	sink := make(recordingSink, 4)
+	watcher := &Watcher{
+		Sinks:    []Sink{sink},
+		Interval: time.Millisecond,
+		Timeout:  time.Second,
+		Misses:   2,
+		Lookup: func(ctx context.Context, _ bool) (ServiceSet, error) {
+			select {
+			case set := <-scans:
+				return set, nil
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		watcher.Run(ctx)
+		close(done)
+	}()
+
+	assert.Equal(t, []ServiceEvent{{Type: Added, Host: "10.0.0.2", Ad: files}}, receiveEvents(t, sink))
+	// unchanged and the first miss send nothing, the second miss removes the ad
+	assert.Equal(t, []ServiceEvent{{Type: Removed, Host: "10.0.0.2", Ad: files}}, receiveEvents(t, sink))
+
+	cancel()
+	<-done
+}
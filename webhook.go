@@ -0,0 +1,172 @@
+package lansrv
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff"
+)
+
+// WebhookSink POSTs the changes to the discovered services to URL as a JSON object with a list of
+// `events`.  Deliveries happen in the background, in order, retrying with exponential backoff
+// while the endpoint can't be reached or answers with a server error.
+type WebhookSink struct {
+	URL string
+	// Secret signs each body with HMAC-SHA256, sent hex encoded as `X-Lansrv-Signature: sha256=...`.
+	Secret []byte
+	// Services limits the events sent to those for the listed services.
+	Services []string
+	// RetryInterval is the first wait between attempts and RetryFor how long to keep trying.
+	RetryInterval time.Duration
+	RetryFor      time.Duration
+	Client        *http.Client
+
+	start  sync.Once
+	queue  chan []ServiceEvent
+	queued sync.WaitGroup
+}
+
+// NewWebhookSink returns a sink posting to @arg url.
+func NewWebhookSink(url string) *WebhookSink {
+	return &WebhookSink{
+		URL:           url,
+		RetryInterval: backoff.DefaultInitialInterval,
+		RetryFor:      backoff.DefaultMaxElapsedTime,
+		Client:        &http.Client{Timeout: 10 * time.Second},
+		queue:         make(chan []ServiceEvent, 64),
+	}
+}
+
+func (sink *WebhookSink) Update(_ ServiceSet, events []ServiceEvent) error {
+	filtered := []ServiceEvent{}
+	for _, event := range events {
+		if sink.wants(event.Ad.Service) {
+			filtered = append(filtered, event)
+		}
+	}
+	if len(filtered) == 0 {
+		return nil
+	}
+
+	sink.start.Do(func() { go sink.deliver() })
+
+	sink.queued.Add(1)
+	select {
+	case sink.queue <- filtered:
+		return nil
+	default:
+		sink.queued.Done()
+		return fmt.Errorf("%s: too many undelivered events, dropping %d", sink.URL, len(filtered))
+	}
+}
+
+// Wait blocks until every queued delivery succeeded or was given up on.
+func (sink *WebhookSink) Wait() {
+	sink.queued.Wait()
+}
+
+func (sink *WebhookSink) wants(service string) bool {
+	if len(sink.Services) == 0 {
+		return true
+	}
+	for _, wanted := range sink.Services {
+		if wanted == service {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (sink *WebhookSink) deliver() {
+	for events := range sink.queue {
+		body, _ := json.Marshal(map[string]interface{}{"events": events})
+
+		retries := backoff.NewExponentialBackOff()
+		retries.InitialInterval, retries.MaxElapsedTime = sink.RetryInterval, sink.RetryFor
+		if err := backoff.Retry(func() error { return sink.post(body) }, retries); err != nil {
+			fmt.Println("Giving up on webhook:", err)
+		}
+
+		sink.queued.Done()
+	}
+}
+
+// post sends @arg body once, marking failures that retrying won't fix as permanent.
+func (sink *WebhookSink) post(body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, sink.URL, bytes.NewReader(body))
+	if err != nil {
+		return backoff.Permanent(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "lansrv")
+	if len(sink.Secret) > 0 {
+		req.Header.Set("X-Lansrv-Signature", "sha256="+WebhookSignature(sink.Secret, body))
+	}
+
+	resp, err := sink.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	ioutil.ReadAll(resp.Body)
+
+	switch {
+	case resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+		return fmt.Errorf("%s: %s", sink.URL, resp.Status)
+	default:
+		return backoff.Permanent(fmt.Errorf("%s: %s", sink.URL, resp.Status))
+	}
+}
+
+// WebhookSignature returns the hex encoded HMAC-SHA256 of @arg body under @arg secret, as sent in
+// the `X-Lansrv-Signature` header.
+func WebhookSignature(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// newWebhookSink takes the `url` to post to, the `secret` or a `secretfile` holding it, the
+// `services` to send events for and how long to `retry` a delivery, e.g. 5m.
+func newWebhookSink(options map[string]string) (Sink, error) {
+	if len(options["url"]) == 0 {
+		return nil, errors.New("url is required")
+	}
+	sink := NewWebhookSink(options["url"])
+
+	sink.Secret = []byte(options["secret"])
+	if path, ok := options["secretfile"]; ok {
+		secret, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		sink.Secret = bytes.TrimSpace(secret)
+	}
+
+	if services, ok := options["services"]; ok {
+		sink.Services = strings.Split(services, ",")
+	}
+
+	if retry, ok := options["retry"]; ok {
+		duration, err := time.ParseDuration(retry)
+		if err != nil {
+			return nil, errors.New("invalid retry " + retry)
+		}
+		sink.RetryFor = duration
+	}
+
+	return sink, nil
+}
@@ -0,0 +1,62 @@
+package lansrv
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebhookSink(t *testing.T) {
+	var attempts int32
+	bodies := make(chan string, 4)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		assert.Equal(t, "sha256="+WebhookSignature([]byte("s3cret"), body), r.Header.Get("X-Lansrv-Signature"))
+
+		// the first delivery fails once and is retried
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		bodies <- string(body)
+	}))
+	defer server.Close()
+
+	sink, err := NewSink("webhook:url=" + server.URL + ",secret=s3cret,services=mqtt,nats-node")
+	assert.NoError(t, err)
+	webhook := sink.(*WebhookSink)
+	webhook.RetryInterval = time.Millisecond
+
+	mqtt := LanAd{Service: "mqtt", Port: 1883, Protocol: "mqtt"}
+	files := LanAd{Service: "files", Port: 9999, Protocol: "http"}
+	assert.NoError(t, sink.Update(nil, []ServiceEvent{{Type: Added, Host: "10.0.0.2", Ad: files}}))
+	assert.NoError(t, sink.Update(nil, []ServiceEvent{
+		{Type: Removed, Host: "10.0.0.3", Ad: mqtt},
+		{Type: Updated, Host: "10.0.0.2", Ad: files},
+	}))
+	webhook.Wait()
+
+	assert.Equal(t, int32(2), atomic.LoadInt32(&attempts))
+	assert.JSONEq(t, `{"events": [{"type":"removed","host":"10.0.0.3","ad":{"Service":"mqtt","Port":1883,"Path":"","Protocol":"mqtt"}}]}`, <-bodies)
+}
+
+func TestWebhookSinkGivesUp(t *testing.T) {
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	webhook := NewWebhookSink(server.URL)
+	assert.NoError(t, webhook.Update(nil, []ServiceEvent{{Type: Added, Host: "10.0.0.2", Ad: LanAd{Service: "files"}}}))
+	webhook.Wait()
+
+	// client errors aren't retried
+	assert.Equal(t, int32(1), atomic.LoadInt32(&attempts))
+}